@@ -3,6 +3,7 @@
 package remote
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/rs/zerolog"
@@ -10,14 +11,18 @@ import (
 
 // Reader provides a read-only interface to a remote file.
 type Reader interface {
-	// PreadRemote is like pread but to a remote file.
-	PreadRemote(buf []byte, offset int64) (int, error)
+	// PreadRemote is like pread but to a remote file. ctx bounds the call, so a caller racing the same read
+	// against multiple Readers can cancel the ones it no longer needs.
+	PreadRemote(ctx context.Context, buf []byte, offset int64) (int, error)
 
-	// FstatRemote stats a remote file.
-	FstatRemote() (int64, error)
+	// FstatRemote stats a remote file. ctx bounds the call.
+	FstatRemote(ctx context.Context) (int64, error)
 
 	// Log returns the logger with context for this reader.
 	Log() *zerolog.Logger
+
+	// Close tears down any resources the Reader holds open, e.g. a pipelined stream to a remote peer.
+	Close() error
 }
 
 // Error describes an error that occured during a remote operation.
@@ -0,0 +1,271 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the Apache License, Version 2.0.
+package remote
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/azure/peerd/internal/routing"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rs/zerolog"
+)
+
+// chunk is a single (offset, length) range of a larger read, fetched from one peer at a time.
+type chunk struct {
+	offset int64
+	length int64
+}
+
+// MultiReader is a Reader that splits a large read across up to a handful of healthy peers in parallel,
+// retries a failed range against an alternate peer, and verifies each chunk it fetches against an expected
+// content digest for that chunk's range.
+type MultiReader struct {
+	peers []routing.PeerInfo
+
+	// newReader constructs a Reader for a given peer, e.g. a StreamReader.
+	newReader func(routing.PeerInfo) Reader
+
+	// digestFunc returns the expected hex-encoded sha256 of the range [offset, offset+length), or "" if the
+	// range shouldn't be verified.
+	digestFunc func(offset, length int64) string
+
+	// redundancy is the number of peers raced per chunk; the first success wins and readChunk cancels the
+	// rest via their shared raceCtx so the stragglers' in-flight requests are cut short instead of left
+	// running for the life of the stream.
+	redundancy int
+
+	mx sync.Mutex
+
+	// readers caches one Reader per peer, keyed by peer.ID, so that every redundancy race and fallback
+	// attempt against a given peer reuses its pipelined stream instead of opening (and leaking) a new one per
+	// chunk.
+	readers map[peer.ID]Reader
+}
+
+// maxFanOut caps how many peers a single read is split across; beyond a handful, the per-chunk overhead
+// outweighs the parallelism gained.
+const maxFanOut = 4
+
+// NewMultiReader drains peerCh, as produced by routing.Router.Resolve, into a fixed peer set and returns a
+// MultiReader that fans reads out across them, verifying each chunk fetched against digestFunc if non-nil.
+func NewMultiReader(peerCh <-chan routing.PeerInfo, digestFunc func(offset, length int64) string, newReader func(routing.PeerInfo) Reader) *MultiReader {
+	mr := &MultiReader{
+		newReader:  newReader,
+		digestFunc: digestFunc,
+		redundancy: 2,
+		readers:    map[peer.ID]Reader{},
+	}
+
+	for p := range peerCh {
+		mr.peers = append(mr.peers, p)
+	}
+
+	return mr
+}
+
+// PreadRemote implements remote.Reader. It splits [offset, offset+len(buf)) across up to maxFanOut peers in
+// parallel, mapping chunk i to a distinct peer so the read actually fans out, and retrying any chunk that
+// errors or fails verification against an alternate peer.
+func (m *MultiReader) PreadRemote(ctx context.Context, buf []byte, offset int64) (int, error) {
+	if len(m.peers) == 0 {
+		return 0, errors.New("no peers available")
+	}
+
+	chunks := m.split(offset, int64(len(buf)))
+	results := make([][]byte, len(chunks))
+	errs := make([]error, len(chunks))
+
+	done := make(chan int, len(chunks))
+	for i, c := range chunks {
+		i, c := i, c
+		go func() {
+			results[i], errs[i] = m.readChunk(ctx, i, c)
+			done <- i
+		}()
+	}
+	for range chunks {
+		<-done
+	}
+
+	n := 0
+	for i := range chunks {
+		if errs[i] != nil {
+			return n, fmt.Errorf("chunk at offset %d: %w", chunks[i].offset, errs[i])
+		}
+		n += copy(buf[n:], results[i])
+	}
+
+	return n, nil
+}
+
+// split divides [offset, offset+length) into up to maxFanOut roughly equal chunks, one per peer.
+func (m *MultiReader) split(offset, length int64) []chunk {
+	n := int64(len(m.peers))
+	if n > maxFanOut {
+		n = maxFanOut
+	}
+
+	size := length / n
+	if size == 0 {
+		return []chunk{{offset: offset, length: length}}
+	}
+
+	chunks := make([]chunk, 0, n)
+	for i := int64(0); i < n; i++ {
+		c := chunk{offset: offset + i*size, length: size}
+		if i == n-1 {
+			c.length = length - i*size
+		}
+		chunks = append(chunks, c)
+	}
+
+	return chunks
+}
+
+// peerOrder returns m.peers rotated so that the peer primarily responsible for chunk index i — peer i, for
+// the first maxFanOut chunks — comes first, followed by the rest as a retry set.
+func (m *MultiReader) peerOrder(index int) []routing.PeerInfo {
+	start := index % len(m.peers)
+
+	order := make([]routing.PeerInfo, 0, len(m.peers))
+	order = append(order, m.peers[start:]...)
+	order = append(order, m.peers[:start]...)
+
+	return order
+}
+
+// reader returns the cached Reader for p, building and caching one via m.newReader on first use, so that every
+// redundancy race and fallback attempt against p reuses the same pipelined stream instead of opening a new one
+// per chunk.
+func (m *MultiReader) reader(p routing.PeerInfo) Reader {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	if r, ok := m.readers[p.ID]; ok {
+		return r
+	}
+
+	r := m.newReader(p)
+	m.readers[p.ID] = r
+	return r
+}
+
+// fetch reads c from p and, if m.digestFunc is configured, verifies the result against the expected digest
+// for c's range, treating a mismatch as a failed fetch. io.EOF with a non-zero read is a legitimate
+// end-of-object partial read, not a failure. ctx bounds the call, so readChunk can cut a fetch short, e.g. a
+// straggler in a redundancy race that already has a winner.
+func (m *MultiReader) fetch(ctx context.Context, p routing.PeerInfo, c chunk) ([]byte, error) {
+	data := make([]byte, c.length)
+	n, err := m.reader(p).PreadRemote(ctx, data, c.offset)
+	if err != nil && !(err == io.EOF && n > 0) {
+		return nil, err
+	}
+	data = data[:n]
+
+	if m.digestFunc != nil {
+		if expected := m.digestFunc(c.offset, c.length); expected != "" && !verifySHA256(data, expected) {
+			return nil, fmt.Errorf("content digest mismatch for chunk at offset %d", c.offset)
+		}
+	}
+
+	return data, nil
+}
+
+// readChunk fetches c from the peer assigned to chunk index (peerOrder(index)[0]), racing it against up to
+// m.redundancy peers from that rotation over a child context; as soon as a winner is found (or every raced
+// peer has failed), that context is cancelled so the stragglers' in-flight requests are cut short instead of
+// left running for the life of the stream. If every raced peer fails or fails verification, it falls back to
+// trying the remaining peers in the rotation one at a time.
+func (m *MultiReader) readChunk(ctx context.Context, index int, c chunk) ([]byte, error) {
+	peers := m.peerOrder(index)
+
+	redundancy := m.redundancy
+	if redundancy > len(peers) {
+		redundancy = len(peers)
+	}
+
+	type result struct {
+		data []byte
+		err  error
+	}
+
+	raceCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	resCh := make(chan result, redundancy)
+	for i := 0; i < redundancy; i++ {
+		p := peers[i]
+		go func() {
+			data, err := m.fetch(raceCtx, p, c)
+			resCh <- result{data: data, err: err}
+		}()
+	}
+
+	var lastErr error
+	for i := 0; i < redundancy; i++ {
+		r := <-resCh
+		if r.err == nil {
+			return r.data, nil
+		}
+		lastErr = r.err
+	}
+
+	for _, p := range peers[redundancy:] {
+		data, err := m.fetch(ctx, p, c)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return data, nil
+	}
+
+	return nil, fmt.Errorf("all peers failed to serve chunk at offset %d: %w", c.offset, lastErr)
+}
+
+// FstatRemote implements remote.Reader by asking the first known peer.
+func (m *MultiReader) FstatRemote(ctx context.Context) (int64, error) {
+	if len(m.peers) == 0 {
+		return 0, errors.New("no peers available")
+	}
+
+	return m.reader(m.peers[0]).FstatRemote(ctx)
+}
+
+// Log implements remote.Reader.
+func (m *MultiReader) Log() *zerolog.Logger {
+	if len(m.peers) == 0 {
+		l := zerolog.Nop()
+		return &l
+	}
+
+	return m.reader(m.peers[0]).Log()
+}
+
+// Close implements remote.Reader. It tears down every Reader opened so far for this MultiReader's peers.
+func (m *MultiReader) Close() error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	var err error
+	for _, r := range m.readers {
+		if cerr := r.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+var _ Reader = &MultiReader{}
+
+// verifySHA256 reports whether the sha256 of data, hex-encoded, equals expected.
+func verifySHA256(data []byte, expected string) bool {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]) == expected
+}
@@ -0,0 +1,212 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the Apache License, Version 2.0.
+package remote
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/azure/peerd/internal/routing"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rs/zerolog"
+)
+
+// fakeReader is a Reader whose PreadRemote behavior is scripted by the test: it returns data, optionally
+// blocks until ctx is cancelled (to stand in for a straggler), and counts calls and cancellations.
+type fakeReader struct {
+	data      []byte
+	err       error
+	block     bool
+	calls     int32
+	cancelled int32
+	closed    int32
+}
+
+func (f *fakeReader) PreadRemote(ctx context.Context, buf []byte, offset int64) (int, error) {
+	atomic.AddInt32(&f.calls, 1)
+
+	if f.block {
+		<-ctx.Done()
+		atomic.AddInt32(&f.cancelled, 1)
+		return 0, ctx.Err()
+	}
+
+	if f.err != nil {
+		return 0, f.err
+	}
+
+	n := copy(buf, f.data)
+	return n, nil
+}
+
+func (f *fakeReader) FstatRemote(ctx context.Context) (int64, error) {
+	return int64(len(f.data)), nil
+}
+
+func (f *fakeReader) Log() *zerolog.Logger {
+	l := zerolog.Nop()
+	return &l
+}
+
+func (f *fakeReader) Close() error {
+	atomic.AddInt32(&f.closed, 1)
+	return nil
+}
+
+var _ Reader = &fakeReader{}
+
+func peers(n int) []routing.PeerInfo {
+	out := make([]routing.PeerInfo, n)
+	for i := range out {
+		out[i] = routing.PeerInfo{ID: peer.ID(string(rune('A' + i)))}
+	}
+	return out
+}
+
+func TestMultiReaderFansOutAcrossPeers(t *testing.T) {
+	ps := peers(2)
+	readers := map[peer.ID]*fakeReader{
+		ps[0].ID: {data: []byte("0123")},
+		ps[1].ID: {data: []byte("4567")},
+	}
+
+	peerCh := make(chan routing.PeerInfo, len(ps))
+	for _, p := range ps {
+		peerCh <- p
+	}
+	close(peerCh)
+
+	mr := NewMultiReader(peerCh, nil, func(p routing.PeerInfo) Reader { return readers[p.ID] })
+
+	buf := make([]byte, 8)
+	n, err := mr.PreadRemote(context.Background(), buf, 0)
+	if err != nil {
+		t.Fatalf("PreadRemote: %v", err)
+	}
+	if n != 8 {
+		t.Fatalf("expected 8 bytes, got %d", n)
+	}
+
+	for _, r := range readers {
+		if atomic.LoadInt32(&r.calls) == 0 {
+			t.Error("expected every peer to be used for its chunk")
+		}
+	}
+}
+
+func TestMultiReaderReusesOneReaderPerPeer(t *testing.T) {
+	ps := peers(1)
+	r := &fakeReader{data: []byte("01234567")}
+
+	var newReaderCalls int32
+	peerCh := make(chan routing.PeerInfo, 1)
+	peerCh <- ps[0]
+	close(peerCh)
+
+	mr := NewMultiReader(peerCh, nil, func(p routing.PeerInfo) Reader {
+		atomic.AddInt32(&newReaderCalls, 1)
+		return r
+	})
+
+	for i := 0; i < 3; i++ {
+		buf := make([]byte, 4)
+		if _, err := mr.PreadRemote(context.Background(), buf, 0); err != nil {
+			t.Fatalf("PreadRemote: %v", err)
+		}
+	}
+
+	if got := atomic.LoadInt32(&newReaderCalls); got != 1 {
+		t.Errorf("expected newReader to be called once and cached, got %d calls", got)
+	}
+
+	if err := mr.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	if atomic.LoadInt32(&r.closed) != 1 {
+		t.Error("expected Close to tear down the cached reader")
+	}
+}
+
+func TestMultiReaderRetriesOnErrorAndDigestMismatch(t *testing.T) {
+	ps := peers(3)
+	readers := map[peer.ID]*fakeReader{
+		ps[0].ID: {err: io.ErrClosedPipe},
+		ps[1].ID: {data: []byte("wrong-data")},
+		ps[2].ID: {data: []byte("good-data!")},
+	}
+
+	digest := func(data []byte) string {
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:])
+	}
+	want := digest([]byte("good-data!"))
+
+	peerCh := make(chan routing.PeerInfo, len(ps))
+	for _, p := range ps {
+		peerCh <- p
+	}
+	close(peerCh)
+
+	mr := NewMultiReader(peerCh, func(offset, length int64) string { return want }, func(p routing.PeerInfo) Reader { return readers[p.ID] })
+	mr.redundancy = 1 // force a deterministic peer-by-peer fallback instead of a race.
+
+	// Drive readChunk directly with a single chunk spanning the whole read, rather than PreadRemote, so the
+	// peer rotation it walks through on failure is deterministic regardless of how split() would have divided
+	// the read across 3 peers.
+	data, err := mr.readChunk(context.Background(), 0, chunk{offset: 0, length: int64(len("good-data!"))})
+	if err != nil {
+		t.Fatalf("readChunk: %v", err)
+	}
+	if string(data) != "good-data!" {
+		t.Errorf("expected the read to fall through to the peer with the matching digest, got %q", data)
+	}
+}
+
+func TestMultiReaderCancelsStragglersOnceARaceHasAWinner(t *testing.T) {
+	ps := peers(2)
+	fast := &fakeReader{data: []byte("0123")}
+	slow := &fakeReader{block: true}
+
+	readers := map[peer.ID]*fakeReader{ps[0].ID: fast, ps[1].ID: slow}
+
+	peerCh := make(chan routing.PeerInfo, len(ps))
+	for _, p := range ps {
+		peerCh <- p
+	}
+	close(peerCh)
+
+	mr := NewMultiReader(peerCh, nil, func(p routing.PeerInfo) Reader { return readers[p.ID] })
+	mr.redundancy = 2
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if _, err := mr.readChunk(context.Background(), 0, chunk{offset: 0, length: 4}); err != nil {
+			t.Errorf("readChunk: %v", err)
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() { wg.Wait(); close(done) }()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for readChunk")
+	}
+
+	for i := 0; i < 100 && atomic.LoadInt32(&slow.cancelled) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if atomic.LoadInt32(&slow.cancelled) == 0 {
+		t.Error("expected the losing peer's in-flight read to be cancelled once the race had a winner")
+	}
+}
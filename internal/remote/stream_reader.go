@@ -0,0 +1,307 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the Apache License, Version 2.0.
+package remote
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/azure/peerd/internal/routing"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/rs/zerolog"
+)
+
+// StreamProtocolID is the libp2p stream protocol used for chunked, request-pipelined range reads, modeled on
+// Bitswap's want/have/block exchange: a client sends a batch of (offset, length) requests on a single stream
+// and the server responds with framed chunks tagged by request ID, so PreadRemote can issue overlapping
+// reads without paying a new stream (and TCP handshake) per call.
+const StreamProtocolID protocol.ID = "/peerd/blocks/1.0.0"
+
+// blockRequest is a single ranged read, or a lightweight HAVE query when Have is set, sent over
+// StreamProtocolID.
+type blockRequest struct {
+	ID     uint64 `json:"id"`
+	Key    string `json:"key"`
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+	Have   bool   `json:"have,omitempty"`
+}
+
+// blockResponse is the framed reply to a blockRequest with the same ID.
+type blockResponse struct {
+	ID   uint64 `json:"id"`
+	Size int64  `json:"size"` // Size is the full remote object size; it's the only field set for a Have response.
+	Data []byte `json:"data,omitempty"`
+	Err  string `json:"err,omitempty"`
+}
+
+// StreamReader is a Reader that issues pipelined, framed range reads to a single remote peer over a
+// dedicated libp2p stream, instead of a new HTTP connection per call.
+type StreamReader struct {
+	host   host.Host
+	peer   peer.ID
+	key    string
+	log    zerolog.Logger
+	health *routing.HealthTracker
+
+	mx      sync.Mutex
+	stream  network.Stream
+	nextID  uint64
+	pending map[uint64]chan blockResponse
+
+	// writeMx serializes Encode calls onto stream, since libp2p streams aren't safe for concurrent writers
+	// and overlapping do() calls are the whole point of this pipelined protocol.
+	writeMx sync.Mutex
+}
+
+// NewStreamReader creates a StreamReader that reads the object identified by key from p. health, if non-nil,
+// is fed a success/failure observation for every call so that Resolve can learn about p's responsiveness;
+// health may be nil, in which case no feedback is recorded.
+func NewStreamReader(h host.Host, p peer.ID, key string, health *routing.HealthTracker, log zerolog.Logger) *StreamReader {
+	return &StreamReader{
+		host:    h,
+		peer:    p,
+		key:     key,
+		health:  health,
+		log:     log.With().Str("peer", p.String()).Str("key", key).Logger(),
+		pending: map[uint64]chan blockResponse{},
+	}
+}
+
+// openStream lazily opens the StreamProtocolID stream to the remote peer, reusing it across calls.
+func (r *StreamReader) openStream(ctx context.Context) (network.Stream, error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	if r.stream != nil {
+		return r.stream, nil
+	}
+
+	s, err := r.host.NewStream(ctx, r.peer, StreamProtocolID)
+	if err != nil {
+		return nil, err
+	}
+
+	r.stream = s
+	go r.readLoop(s)
+
+	return s, nil
+}
+
+// readLoop decodes framed blockResponses off s for the lifetime of the stream and delivers each one to the
+// pending request it answers.
+func (r *StreamReader) readLoop(s network.Stream) {
+	dec := json.NewDecoder(bufio.NewReader(s))
+	for {
+		var resp blockResponse
+		if err := dec.Decode(&resp); err != nil {
+			r.failPending(s, err)
+			return
+		}
+
+		r.mx.Lock()
+		ch, ok := r.pending[resp.ID]
+		delete(r.pending, resp.ID)
+		r.mx.Unlock()
+
+		if ok {
+			ch <- resp
+		}
+	}
+}
+
+// failPending delivers err to every request still awaiting a response, e.g. because the stream broke, closes
+// the now-dead stream s so it isn't leaked, and drops it so the next call reopens one.
+func (r *StreamReader) failPending(s network.Stream, err error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	for id, ch := range r.pending {
+		ch <- blockResponse{ID: id, Err: err.Error()}
+		delete(r.pending, id)
+	}
+
+	if r.stream == s {
+		r.stream = nil
+	}
+	s.Close()
+}
+
+// do sends req over the stream and blocks until its matching response arrives or ctx is done, recording the
+// outcome against r.health so Resolve can learn about r.peer's responsiveness. A context.Canceled error is
+// not recorded as a failure: MultiReader's redundancy race cancels every losing peer's ctx as soon as one
+// wins, and that peer didn't actually fail anything, so counting it would demote healthy, fast peers just
+// for losing a race.
+func (r *StreamReader) do(ctx context.Context, req blockRequest) (blockResponse, error) {
+	req.Key = r.key
+
+	start := time.Now()
+	resp, err := r.doRequest(ctx, req)
+
+	if r.health != nil {
+		if err != nil && !errors.Is(err, context.Canceled) {
+			r.health.RecordFailure(r.peer)
+		} else if err == nil {
+			r.health.RecordSuccess(r.peer, time.Since(start))
+		}
+	}
+
+	return resp, err
+}
+
+// doRequest sends req over the stream and blocks until its matching response arrives or ctx is done. If ctx
+// is done first, the now-unwanted pending entry is dropped so a cancelled straggler doesn't linger in
+// r.pending forever waiting for a response nothing will read.
+func (r *StreamReader) doRequest(ctx context.Context, req blockRequest) (blockResponse, error) {
+	s, err := r.openStream(ctx)
+	if err != nil {
+		return blockResponse{}, err
+	}
+
+	ch := make(chan blockResponse, 1)
+
+	r.mx.Lock()
+	req.ID = r.nextID
+	r.nextID++
+	r.pending[req.ID] = ch
+	r.mx.Unlock()
+
+	r.writeMx.Lock()
+	err = json.NewEncoder(s).Encode(req)
+	r.writeMx.Unlock()
+	if err != nil {
+		return blockResponse{}, err
+	}
+
+	select {
+	case resp := <-ch:
+		if resp.Err != "" {
+			return blockResponse{}, errors.New(resp.Err)
+		}
+		return resp, nil
+	case <-ctx.Done():
+		r.mx.Lock()
+		delete(r.pending, req.ID)
+		r.mx.Unlock()
+		return blockResponse{}, ctx.Err()
+	}
+}
+
+// PreadRemote implements remote.Reader.
+func (r *StreamReader) PreadRemote(ctx context.Context, buf []byte, offset int64) (int, error) {
+	resp, err := r.do(ctx, blockRequest{Offset: offset, Length: int64(len(buf))})
+	if err != nil {
+		return 0, err
+	}
+
+	n := copy(buf, resp.Data)
+	if n < len(buf) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// FstatRemote implements remote.Reader. It is answered as a HAVE query on the same stream protocol, so
+// directory listings don't pay a full HTTP round trip.
+func (r *StreamReader) FstatRemote(ctx context.Context) (int64, error) {
+	resp, err := r.do(ctx, blockRequest{Have: true})
+	if err != nil {
+		return 0, err
+	}
+
+	return resp.Size, nil
+}
+
+// Log implements remote.Reader.
+func (r *StreamReader) Log() *zerolog.Logger {
+	return &r.log
+}
+
+// Close implements remote.Reader. It closes the underlying stream, if one is open, which unblocks readLoop's
+// Decode with an error so the goroutine exits instead of blocking forever; a subsequent call reopens a fresh
+// stream.
+func (r *StreamReader) Close() error {
+	r.mx.Lock()
+	s := r.stream
+	r.stream = nil
+	r.mx.Unlock()
+
+	if s == nil {
+		return nil
+	}
+
+	return s.Close()
+}
+
+var _ Reader = &StreamReader{}
+
+// BlockProvider answers the local end of a StreamProtocolID exchange: range reads and size queries against
+// objects this node holds.
+type BlockProvider interface {
+	// PreadLocal reads len(buf) bytes of the object identified by key at offset.
+	PreadLocal(key string, buf []byte, offset int64) (int, error)
+
+	// FstatLocal returns the size of the object identified by key.
+	FstatLocal(key string) (int64, error)
+}
+
+// RegisterStreamHandler installs the StreamProtocolID handler that answers incoming range and HAVE requests
+// against provider, so that a StreamReader opened by a remote peer against this host actually gets a response.
+func RegisterStreamHandler(h host.Host, provider BlockProvider, log zerolog.Logger) {
+	h.SetStreamHandler(StreamProtocolID, func(s network.Stream) {
+		defer s.Close()
+		handleBlockStream(s, provider, log)
+	})
+}
+
+// handleBlockStream decodes blockRequests off s and writes back a framed blockResponse for each, until the
+// stream is closed or a request fails to decode.
+func handleBlockStream(s network.Stream, provider BlockProvider, log zerolog.Logger) {
+	dec := json.NewDecoder(bufio.NewReader(s))
+	enc := json.NewEncoder(s)
+
+	for {
+		var req blockRequest
+		if err := dec.Decode(&req); err != nil {
+			if err != io.EOF {
+				log.Debug().Err(err).Str("peer", s.Conn().RemotePeer().String()).Msg("failed to decode block request")
+			}
+			return
+		}
+
+		resp := serveBlockRequest(provider, req)
+		if err := enc.Encode(resp); err != nil {
+			log.Debug().Err(err).Str("peer", s.Conn().RemotePeer().String()).Msg("failed to write block response")
+			return
+		}
+	}
+}
+
+// serveBlockRequest answers a single blockRequest against provider: a HAVE query returns the object's size
+// with no data, and a ranged read returns the requested bytes.
+func serveBlockRequest(provider BlockProvider, req blockRequest) blockResponse {
+	if req.Have {
+		size, err := provider.FstatLocal(req.Key)
+		if err != nil {
+			return blockResponse{ID: req.ID, Err: err.Error()}
+		}
+		return blockResponse{ID: req.ID, Size: size}
+	}
+
+	buf := make([]byte, req.Length)
+	n, err := provider.PreadLocal(req.Key, buf, req.Offset)
+	if err != nil && err != io.EOF {
+		return blockResponse{ID: req.ID, Err: err.Error()}
+	}
+
+	return blockResponse{ID: req.ID, Data: buf[:n]}
+}
@@ -0,0 +1,232 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the Apache License, Version 2.0.
+package remote
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/azure/peerd/internal/routing"
+	"github.com/libp2p/go-libp2p/core/network"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/rs/zerolog"
+)
+
+// fakeBlockProvider serves PreadLocal/FstatLocal out of an in-memory byte slice, so StreamReader can be
+// round-tripped against a real libp2p stream without a real object store behind it.
+type fakeBlockProvider struct {
+	data []byte
+}
+
+func (p *fakeBlockProvider) PreadLocal(key string, buf []byte, offset int64) (int, error) {
+	if offset >= int64(len(p.data)) {
+		return 0, io.EOF
+	}
+	n := copy(buf, p.data[offset:])
+	if int64(n) < int64(len(buf)) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (p *fakeBlockProvider) FstatLocal(key string) (int64, error) {
+	return int64(len(p.data)), nil
+}
+
+var _ BlockProvider = &fakeBlockProvider{}
+
+func TestStreamReaderRoundTrip(t *testing.T) {
+	mn := mocknet.New()
+	defer mn.Close()
+
+	serverHost, err := mn.GenPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientHost, err := mn.GenPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mn.ConnectAllButSelf(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []byte("hello from the remote peer, served over a pipelined stream")
+	RegisterStreamHandler(serverHost, &fakeBlockProvider{data: want}, zerolog.Nop())
+
+	r := NewStreamReader(clientHost, serverHost.ID(), "some-key", nil, zerolog.Nop())
+	defer r.Close()
+
+	size, err := r.FstatRemote(context.Background())
+	if err != nil {
+		t.Fatalf("FstatRemote: %v", err)
+	}
+	if size != int64(len(want)) {
+		t.Errorf("expected size %d, got %d", len(want), size)
+	}
+
+	buf := make([]byte, len(want))
+	n, err := r.PreadRemote(context.Background(), buf, 0)
+	if err != nil && err != io.EOF {
+		t.Fatalf("PreadRemote: %v", err)
+	}
+	if !bytes.Equal(buf[:n], want) {
+		t.Errorf("expected %q, got %q", want, buf[:n])
+	}
+
+	// A second, overlapping read over the same pipelined stream should reuse the already-open stream rather
+	// than opening a new one.
+	partial := make([]byte, 5)
+	n, err = r.PreadRemote(context.Background(), partial, 6)
+	if err != nil {
+		t.Fatalf("PreadRemote (second call): %v", err)
+	}
+	if string(partial[:n]) != string(want[6:11]) {
+		t.Errorf("expected %q, got %q", want[6:11], partial[:n])
+	}
+}
+
+func TestStreamReaderPreadRemoteCancelled(t *testing.T) {
+	mn := mocknet.New()
+	defer mn.Close()
+
+	serverHost, err := mn.GenPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientHost, err := mn.GenPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mn.ConnectAllButSelf(); err != nil {
+		t.Fatal(err)
+	}
+
+	// serverHost accepts StreamProtocolID streams but never answers: requests are sent but never answered, so
+	// the call only returns once ctx is cancelled.
+	serverHost.SetStreamHandler(StreamProtocolID, func(s network.Stream) {
+		defer s.Close()
+		_, _ = io.Copy(io.Discard, s)
+	})
+
+	r := NewStreamReader(clientHost, serverHost.ID(), "some-key", nil, zerolog.Nop())
+	defer r.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err = r.PreadRemote(ctx, make([]byte, 4), 0)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	r.mx.Lock()
+	pending := len(r.pending)
+	r.mx.Unlock()
+	if pending != 0 {
+		t.Errorf("expected the cancelled request to be dropped from pending, got %d left", pending)
+	}
+}
+
+func TestStreamReaderDoesNotRecordHealthFailureOnCancel(t *testing.T) {
+	mn := mocknet.New()
+	defer mn.Close()
+
+	serverHost, err := mn.GenPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientHost, err := mn.GenPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mn.ConnectAllButSelf(); err != nil {
+		t.Fatal(err)
+	}
+
+	// serverHost accepts StreamProtocolID streams but never answers: the request is never answered, so
+	// PreadRemote only returns once ctx is explicitly cancelled below, rather than hitting a deadline.
+	serverHost.SetStreamHandler(StreamProtocolID, func(s network.Stream) {
+		defer s.Close()
+		_, _ = io.Copy(io.Discard, s)
+	})
+
+	health := routing.NewHealthTracker()
+	r := NewStreamReader(clientHost, serverHost.ID(), "some-key", health, zerolog.Nop())
+	defer r.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+
+	if _, err := r.PreadRemote(ctx, make([]byte, 4), 0); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	// MultiReader cancels every losing peer in a redundancy race this same way; that peer didn't actually
+	// fail anything, so it shouldn't be demoted by HealthTracker.
+	if got := health.Snapshot(serverHost.ID()); got.Failures != 0 {
+		t.Errorf("expected a cancelled request not to count as a health failure, got %d", got.Failures)
+	}
+}
+
+func TestStreamReaderRecordsHealthFailureOnRealError(t *testing.T) {
+	mn := mocknet.New()
+	defer mn.Close()
+
+	serverHost, err := mn.GenPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientHost, err := mn.GenPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mn.ConnectAllButSelf(); err != nil {
+		t.Fatal(err)
+	}
+
+	// serverHost accepts StreamProtocolID streams but never answers, so the deadline fires: a genuine failure,
+	// not a cancellation the caller asked for, so it should still be recorded.
+	serverHost.SetStreamHandler(StreamProtocolID, func(s network.Stream) {
+		defer s.Close()
+		_, _ = io.Copy(io.Discard, s)
+	})
+
+	health := routing.NewHealthTracker()
+	r := NewStreamReader(clientHost, serverHost.ID(), "some-key", health, zerolog.Nop())
+	defer r.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if _, err := r.PreadRemote(ctx, make([]byte, 4), 0); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	if got := health.Snapshot(serverHost.ID()); got.Failures == 0 {
+		t.Error("expected a genuine timeout to be recorded as a health failure")
+	}
+}
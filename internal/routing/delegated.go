@@ -0,0 +1,321 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the Apache License, Version 2.0.
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	corerouting "github.com/libp2p/go-libp2p/core/routing"
+	multiaddr "github.com/multiformats/go-multiaddr"
+	multihash "github.com/multiformats/go-multihash"
+	"github.com/rs/zerolog/log"
+)
+
+// ContentID derives the CID a router advertises and resolves a key under.
+func ContentID(key string) (cid.Cid, error) {
+	mh, err := multihash.Sum([]byte(key), multihash.SHA2_256, -1)
+	if err != nil {
+		return cid.Cid{}, err
+	}
+	return cid.NewCidV1(cid.Raw, mh), nil
+}
+
+// delegatedProvidersPath is the IPIP-417 path that a delegated routing endpoint answers, relative to its base URL.
+const delegatedProvidersPath = "/routing/v1/providers/"
+
+// peerRecord is the wire format for a single provider entry in a delegated routing response.
+type peerRecord struct {
+	ID        string   `json:"ID"`
+	Addrs     []string `json:"Addrs"`
+	Protocols []string `json:"Protocols,omitempty"`
+	HttpHost  string   `json:"HttpHost,omitempty"`
+}
+
+// addrInfo decodes a peerRecord into a libp2p peer.AddrInfo, skipping any addresses that fail to parse.
+func (p *peerRecord) addrInfo() (peer.AddrInfo, error) {
+	id, err := peer.Decode(p.ID)
+	if err != nil {
+		return peer.AddrInfo{}, err
+	}
+
+	addrs := make([]multiaddr.Multiaddr, 0, len(p.Addrs))
+	for _, a := range p.Addrs {
+		ma, err := multiaddr.NewMultiaddr(a)
+		if err != nil {
+			continue
+		}
+		addrs = append(addrs, ma)
+	}
+
+	return peer.AddrInfo{ID: id, Addrs: addrs}, nil
+}
+
+// providersResponse is the JSON body of a delegated routing GET /routing/v1/providers/{cid} response.
+type providersResponse struct {
+	Providers []peerRecord `json:"Providers"`
+}
+
+// HttpRouter is a delegated, HTTP-based content router as described in IPIP-417. It queries one or more
+// remote delegated routing endpoints for providers of a CID, and is meant to be raced alongside the libp2p
+// DHT/discovery lookup that router otherwise performs, for clusters that can't run a full DHT or that want a
+// fast, cache-friendly HTTP path.
+type HttpRouter struct {
+	endpoints []string
+	client    *http.Client
+}
+
+// NewHttpRouter creates an HttpRouter that queries the given delegated routing endpoints, e.g. a well-known
+// bootstrap node or an in-cluster indexer service.
+func NewHttpRouter(endpoints []string) *HttpRouter {
+	return &HttpRouter{
+		endpoints: endpoints,
+		client:    &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// findProviders queries all configured endpoints concurrently and streams back whatever provider records they
+// return, HttpHost included, without blocking on an endpoint that is slow or down beyond the client timeout.
+// Unlike FindProvidersAsync, it keeps the HttpHost a delegated endpoint advertised for a peer instead of
+// discarding it, so callers like ResolveWithHttp can still reach a peer whose real HTTP host differs from
+// Addr:peerRegistryPort.
+func (h *HttpRouter) findProviders(ctx context.Context, c cid.Cid, count int) <-chan peerRecord {
+	out := make(chan peerRecord, count)
+
+	go func() {
+		defer close(out)
+
+		var wg sync.WaitGroup
+		for _, endpoint := range h.endpoints {
+			wg.Add(1)
+			go func(endpoint string) {
+				defer wg.Done()
+				h.queryEndpoint(ctx, endpoint, c, out)
+			}(endpoint)
+		}
+		wg.Wait()
+	}()
+
+	return out
+}
+
+// FindProvidersAsync implements routing.ContentRouting. It queries all configured endpoints concurrently and
+// streams back whatever providers they return, without blocking on an endpoint that is slow or down beyond
+// the client timeout.
+func (h *HttpRouter) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan peer.AddrInfo {
+	out := make(chan peer.AddrInfo, count)
+
+	go func() {
+		defer close(out)
+
+		for rec := range h.findProviders(ctx, c, count) {
+			ai, err := rec.addrInfo()
+			if err != nil {
+				continue
+			}
+
+			select {
+			case out <- ai:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// queryEndpoint issues a single delegated routing lookup against endpoint and forwards any provider records it
+// returns onto out.
+func (h *HttpRouter) queryEndpoint(ctx context.Context, endpoint string, c cid.Cid, out chan<- peerRecord) {
+	url := endpoint + delegatedProvidersPath + c.String()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		log.Warn().Err(err).Str("endpoint", endpoint).Msg("failed to build delegated routing request")
+		return
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := h.client.Do(req)
+	if err != nil {
+		log.Debug().Err(err).Str("endpoint", endpoint).Msg("delegated routing endpoint unreachable")
+		return
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		log.Debug().Int("status", res.StatusCode).Str("endpoint", endpoint).Msg("delegated routing endpoint returned non-200")
+		return
+	}
+
+	var body providersResponse
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		log.Warn().Err(err).Str("endpoint", endpoint).Msg("failed to decode delegated routing response")
+		return
+	}
+
+	for _, p := range body.Providers {
+		select {
+		case out <- p:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Provide is a no-op: HttpRouter is a read-only, consumer-side view of one or more delegated routing
+// endpoints and does not itself accept advertisements.
+func (h *HttpRouter) Provide(ctx context.Context, c cid.Cid, advertise bool) error {
+	return nil
+}
+
+var _ corerouting.ContentRouting = &HttpRouter{}
+
+// ProviderLookup resolves a CID, in the string form it takes in the request path, to the peers currently
+// known to provide it, and reports whether the CID is known at all. Implementations are expected to reuse the same advertised-CID and negative-cache state that
+// the libp2p discovery path already maintains.
+type ProviderLookup func(key string) ([]PeerInfo, bool)
+
+// HttpServer answers delegated routing HTTP requests for the CIDs this node has advertised, so that nodes
+// without a full DHT can still resolve content through this node.
+type HttpServer struct {
+	lookup           ProviderLookup
+	peerRegistryPort string
+}
+
+// NewHttpServer creates an HttpServer that answers lookups with the given ProviderLookup. peerRegistryPort
+// is the router's own HTTP peer registry port, reused here so the dialable address advertised for each peer
+// matches the one the router already serves on.
+func NewHttpServer(peerRegistryPort string, lookup ProviderLookup) *HttpServer {
+	return &HttpServer{lookup: lookup, peerRegistryPort: peerRegistryPort}
+}
+
+// ServeHTTP implements http.Handler, and should be mounted at the IPIP-417 providers path on the node's
+// existing peer registry HTTP server.
+func (s *HttpServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, delegatedProvidersPath)
+	if key == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	peers, ok := s.lookup(key)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	resp := providersResponse{Providers: make([]peerRecord, 0, len(peers))}
+	for _, p := range peers {
+		httpHost := p.HttpHost
+		if httpHost == "" {
+			httpHost = fmt.Sprintf("https://%s:%s", p.Addr, s.peerRegistryPort)
+		}
+
+		resp.Providers = append(resp.Providers, peerRecord{
+			ID:       p.ID.String(),
+			Addrs:    []string{fmt.Sprintf("/ip4/%s/tcp/%s/https", p.Addr, s.peerRegistryPort)},
+			HttpHost: httpHost,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Error().Err(err).Str("key", key).Msg("failed to encode delegated routing response")
+	}
+}
+
+var _ http.Handler = &HttpServer{}
+
+// ResolveWithHttp races a delegated HTTP lookup for key against an already in-flight libp2p resolution for
+// the same key, merging both into a single deduplicated PeerInfo channel that closes once both sources are
+// exhausted. A concrete Router.Resolve/ResolveWithNegativeCacheCallback implementation calls this to plug
+// HttpRouter in alongside the libp2p DHT/discovery path, instead of returning the libp2p channel as-is.
+// httpRouter may be nil, in which case libp2p is passed through unchanged.
+func ResolveWithHttp(ctx context.Context, key string, libp2p <-chan PeerInfo, httpRouter *HttpRouter) <-chan PeerInfo {
+	if httpRouter == nil {
+		return libp2p
+	}
+
+	out := make(chan PeerInfo, cap(libp2p)+4)
+
+	go func() {
+		defer close(out)
+
+		var mx sync.Mutex
+		seen := map[peer.ID]struct{}{}
+		forward := func(p PeerInfo) bool {
+			mx.Lock()
+			_, dup := seen[p.ID]
+			seen[p.ID] = struct{}{}
+			mx.Unlock()
+			if dup {
+				return true
+			}
+
+			select {
+			case out <- p:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		var wg sync.WaitGroup
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range libp2p {
+				if !forward(p) {
+					return
+				}
+			}
+		}()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			c, err := ContentID(key)
+			if err != nil {
+				log.Warn().Err(err).Str("key", key).Msg("failed to derive cid for delegated routing lookup")
+				return
+			}
+
+			for rec := range httpRouter.findProviders(ctx, c, 4) {
+				ai, err := rec.addrInfo()
+				if err != nil {
+					continue
+				}
+
+				pi := PeerInfo{ID: ai.ID, HttpHost: rec.HttpHost}
+				if len(ai.Addrs) > 0 {
+					if v, err := ai.Addrs[0].ValueForProtocol(multiaddr.P_IP4); err == nil {
+						pi.Addr = v
+					}
+				}
+
+				if !forward(pi) {
+					return
+				}
+			}
+		}()
+
+		wg.Wait()
+	}()
+
+	return out
+}
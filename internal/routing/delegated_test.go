@@ -0,0 +1,128 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the Apache License, Version 2.0.
+package routing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/test"
+)
+
+func TestHttpServerAndRouter(t *testing.T) {
+	id, err := test.RandPeerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := ContentID("some-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lookup := func(k string) ([]PeerInfo, bool) {
+		if k != c.String() {
+			return nil, false
+		}
+		return []PeerInfo{{ID: id, Addr: "10.0.0.1"}}, true
+	}
+
+	srv := httptest.NewServer(NewHttpServer("5000", lookup))
+	defer srv.Close()
+
+	r := NewHttpRouter([]string{srv.URL})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	var found *peer.AddrInfo
+	for ai := range r.FindProvidersAsync(ctx, c, 1) {
+		ai := ai
+		found = &ai
+	}
+
+	if found == nil {
+		t.Fatal("expected a provider")
+	}
+
+	if found.ID != id {
+		t.Errorf("expected peer %s, got %s", id, found.ID)
+	}
+}
+
+func TestHttpServerUnknownKey(t *testing.T) {
+	lookup := func(k string) ([]PeerInfo, bool) { return nil, false }
+
+	srv := httptest.NewServer(NewHttpServer("5000", lookup))
+	defer srv.Close()
+
+	c, err := ContentID("missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.Get(srv.URL + delegatedProvidersPath + c.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", res.StatusCode)
+	}
+}
+
+func TestResolveWithHttp(t *testing.T) {
+	httpID, err := test.RandPeerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	libp2pID, err := test.RandPeerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := ContentID("some-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lookup := func(k string) ([]PeerInfo, bool) {
+		if k != c.String() {
+			return nil, false
+		}
+		return []PeerInfo{{ID: httpID, Addr: "10.0.0.1", HttpHost: "https://bootstrap.example:9000"}}, true
+	}
+
+	srv := httptest.NewServer(NewHttpServer("5000", lookup))
+	defer srv.Close()
+
+	libp2pCh := make(chan PeerInfo, 1)
+	libp2pCh <- PeerInfo{ID: libp2pID, Addr: "10.0.0.2"}
+	close(libp2pCh)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	merged := ResolveWithHttp(ctx, "some-key", libp2pCh, NewHttpRouter([]string{srv.URL}))
+
+	seen := map[peer.ID]PeerInfo{}
+	for p := range merged {
+		seen[p.ID] = p
+	}
+
+	if _, ok := seen[httpID]; !ok {
+		t.Errorf("expected both peers to be merged, got %v", seen)
+	}
+	if _, ok := seen[libp2pID]; !ok {
+		t.Errorf("expected both peers to be merged, got %v", seen)
+	}
+
+	if got := seen[httpID].HttpHost; got != "https://bootstrap.example:9000" {
+		t.Errorf("expected delegated HttpHost to survive the merge, got %q", got)
+	}
+}
@@ -0,0 +1,323 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the Apache License, Version 2.0.
+package routing
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	p2pcontext "github.com/azure/peerd/internal/context"
+	"github.com/azure/peerd/internal/k8s/events"
+	"github.com/azure/peerd/pkg/k8s"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	multiaddr "github.com/multiformats/go-multiaddr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// persistentPeersAnnotation is the Kubernetes annotation, on this node's Node or Pod object, that holds a
+// comma-separated list of multiaddrs the local host must always keep a live connection to.
+const persistentPeersAnnotation = "peerd.azure.microsoft.com/persistent-peers"
+
+const (
+	minReconnectBackoff = 1 * time.Second
+	maxReconnectBackoff = 2 * time.Minute
+)
+
+// persistentPeerManager keeps a live connection to a fixed set of peers, reconnecting with exponential
+// backoff whenever one drops, and reports P2PConnected/P2PDisconnected transitions through recorder.
+type persistentPeerManager struct {
+	host     host.Host
+	recorder events.Recorder
+	peers    []peer.AddrInfo
+
+	// ctx bounds the lifetime of reconnect goroutines started from Disconnected. It's set once by Start,
+	// strictly before the manager is registered to receive network notifications, so Disconnected can read
+	// it without further synchronization.
+	ctx context.Context
+
+	network.Notifiee
+}
+
+// newPersistentPeerManager creates a persistentPeerManager for the given peers, which must already have
+// resolvable addresses.
+func newPersistentPeerManager(h host.Host, recorder events.Recorder, peers []peer.AddrInfo) *persistentPeerManager {
+	m := &persistentPeerManager{
+		host:     h,
+		recorder: recorder,
+		peers:    peers,
+		Notifiee: &network.NoopNotifiee{},
+	}
+
+	return m
+}
+
+// Start connects to every persistent peer and registers for disconnect notifications so they can be
+// reconnected automatically for as long as ctx remains live.
+func (m *persistentPeerManager) Start(ctx context.Context) {
+	m.ctx = ctx
+	m.host.Network().Notify(m)
+
+	for _, p := range m.peers {
+		p := p
+		m.host.Peerstore().AddAddrs(p.ID, p.Addrs, peerstoreTTL)
+		go m.connectWithBackoff(ctx, p.ID)
+	}
+}
+
+// Disconnected implements network.Notifiee. It reconnects a persistent peer once it drops, using
+// exponential backoff so a flapping peer doesn't cause a reconnect storm, bounded by the manager's own
+// lifetime context so reconnect attempts stop once the manager is shut down.
+func (m *persistentPeerManager) Disconnected(_ network.Network, c network.Conn) {
+	p := c.RemotePeer()
+	if !m.isPersistent(p) {
+		return
+	}
+
+	m.recorder.Disconnected()
+	go m.connectWithBackoff(m.ctx, p)
+}
+
+// isPersistent reports whether p is one of the peers this manager keeps connected.
+func (m *persistentPeerManager) isPersistent(p peer.ID) bool {
+	for _, persistent := range m.peers {
+		if persistent.ID == p {
+			return true
+		}
+	}
+	return false
+}
+
+// connectWithBackoff retries Connect against p with exponential backoff, doubling the delay after each
+// failure up to maxReconnectBackoff, until it succeeds or ctx is done.
+func (m *persistentPeerManager) connectWithBackoff(ctx context.Context, p peer.ID) {
+	backoff := minReconnectBackoff
+
+	for {
+		if m.host.Network().Connectedness(p) == network.Connected {
+			return
+		}
+
+		for _, persistent := range m.peers {
+			if persistent.ID == p {
+				m.host.Peerstore().AddAddrs(p, persistent.Addrs, peerstoreTTL)
+			}
+		}
+
+		if err := m.host.Connect(ctx, m.host.Peerstore().PeerInfo(p)); err == nil {
+			m.recorder.Connected()
+			return
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+
+		backoff *= 2
+		if backoff > maxReconnectBackoff {
+			backoff = maxReconnectBackoff
+		}
+	}
+}
+
+// peerstoreTTL is how long a persistent peer's addresses are kept in the peerstore before they'd otherwise
+// be considered stale; the manager refreshes them on every (re)connect attempt regardless.
+const peerstoreTTL = 10 * time.Minute
+
+// persistentPeersFromAnnotation reads the comma-separated multiaddr list from this node's persistentPeersAnnotation,
+// looking at the local Pod's annotations if running in a pod, or the Node's otherwise.
+func persistentPeersFromAnnotation(ctx context.Context, cs *k8s.ClientSet) ([]string, error) {
+	var annotations map[string]string
+
+	if cs.InPod {
+		pod, err := cs.CoreV1().Pods(p2pcontext.Namespace).Get(ctx, p2pcontext.NodeName, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		annotations = pod.Annotations
+	} else {
+		node, err := cs.CoreV1().Nodes().Get(ctx, p2pcontext.NodeName, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		annotations = node.Annotations
+	}
+
+	v, ok := annotations[persistentPeersAnnotation]
+	if !ok || v == "" {
+		return nil, nil
+	}
+
+	return splitAndTrim(v), nil
+}
+
+// splitAndTrim splits a comma-separated list, discarding empty entries.
+func splitAndTrim(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// parseAddrInfos parses a list of multiaddr strings into peer.AddrInfo, grouping addresses that share a peer
+// ID. It returns an error if any address fails to parse or has no peer ID component.
+func parseAddrInfos(addrs []string) ([]peer.AddrInfo, error) {
+	mas := make([]multiaddr.Multiaddr, 0, len(addrs))
+	for _, a := range addrs {
+		ma, err := multiaddr.NewMultiaddr(a)
+		if err != nil {
+			return nil, err
+		}
+		mas = append(mas, ma)
+	}
+
+	return peer.AddrInfosFromP2pAddrs(mas...)
+}
+
+// StartPersistentPeers resolves the persistent peer set — configured addrs plus whatever is published on
+// this node's persistentPeersAnnotation — and starts a persistentPeerManager that keeps them connected for
+// the lifetime of ctx.
+func StartPersistentPeers(ctx context.Context, h host.Host, recorder events.Recorder, cs *k8s.ClientSet, configured []string) error {
+	annotated, err := persistentPeersFromAnnotation(ctx, cs)
+	if err != nil {
+		return err
+	}
+
+	infos, err := parseAddrInfos(append(append([]string{}, configured...), annotated...))
+	if err != nil {
+		return err
+	}
+
+	if len(infos) == 0 {
+		return nil
+	}
+
+	newPersistentPeerManager(h, recorder, infos).Start(ctx)
+
+	return nil
+}
+
+// HealthTracker records connection-quality feedback for peers observed via Resolve, so that Resolve can
+// prefer healthy peers and demote ones that have recently failed a PreadRemote or FstatRemote call.
+type HealthTracker struct {
+	mx     sync.RWMutex
+	health map[peer.ID]PeerHealth
+}
+
+// NewHealthTracker creates an empty HealthTracker.
+func NewHealthTracker() *HealthTracker {
+	return &HealthTracker{health: map[peer.ID]PeerHealth{}}
+}
+
+// RecordSuccess records a successful round trip to p, resetting its failure count.
+func (h *HealthTracker) RecordSuccess(p peer.ID, rtt time.Duration) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	h.health[p] = PeerHealth{RTT: rtt, LastSeen: time.Now()}
+}
+
+// RecordFailure records a failed PreadRemote/FstatRemote call against p, incrementing its failure count.
+func (h *HealthTracker) RecordFailure(p peer.ID) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	ph := h.health[p]
+	ph.Failures++
+	h.health[p] = ph
+}
+
+// Snapshot returns the current PeerHealth recorded for p, the zero value if none has been recorded yet.
+func (h *HealthTracker) Snapshot(p peer.ID) PeerHealth {
+	h.mx.RLock()
+	defer h.mx.RUnlock()
+	return h.health[p]
+}
+
+// Sort orders infos so that healthy peers (fewer recent failures, then lower RTT) sort first, demoting
+// peers that have recently failed a remote read.
+func (h *HealthTracker) Sort(infos []PeerInfo) {
+	h.mx.RLock()
+	defer h.mx.RUnlock()
+
+	for i := range infos {
+		infos[i].Health = h.health[infos[i].ID]
+	}
+
+	sort.SliceStable(infos, func(i, j int) bool {
+		if infos[i].Health.Failures != infos[j].Health.Failures {
+			return infos[i].Health.Failures < infos[j].Health.Failures
+		}
+		return infos[i].Health.RTT < infos[j].Health.RTT
+	})
+}
+
+// healthAwareFlushWindow is how long ResolveHealthAware waits after a peer arrives for more to join it before
+// sorting and emitting the batch, so a cluster of near-simultaneous arrivals still gets ordered together
+// without withholding every peer until the slowest upstream source (DHT/HTTP lookup) is exhausted.
+const healthAwareFlushWindow = 50 * time.Millisecond
+
+// ResolveHealthAware re-sorts in with tracker and streams the result on the returned channel, flushing
+// whatever has arrived so far every healthAwareFlushWindow instead of buffering until in is exhausted, so
+// Resolve's callers still see the first healthy peer as soon as one turns up. Like ResolveWithHttp, every
+// send onto the returned channel is selected against ctx.Done(), so an abandoned Resolve call (caller
+// stopped reading after cancelling ctx) doesn't leave this goroutine blocked forever. A concrete
+// Router.Resolve implementation calls this to apply health-aware ordering on top of the libp2p/HTTP-merged
+// peer stream.
+func ResolveHealthAware(ctx context.Context, in <-chan PeerInfo, tracker *HealthTracker) <-chan PeerInfo {
+	out := make(chan PeerInfo, cap(in))
+
+	go func() {
+		defer close(out)
+
+		var pending []PeerInfo
+		var timerC <-chan time.Time
+
+		flush := func() bool {
+			if len(pending) == 0 {
+				return true
+			}
+			tracker.Sort(pending)
+			for _, p := range pending {
+				select {
+				case out <- p:
+				case <-ctx.Done():
+					return false
+				}
+			}
+			pending = nil
+			timerC = nil
+			return true
+		}
+
+		for {
+			select {
+			case p, ok := <-in:
+				if !ok {
+					flush()
+					return
+				}
+
+				pending = append(pending, p)
+				if timerC == nil {
+					timerC = time.After(healthAwareFlushWindow)
+				}
+			case <-timerC:
+				if !flush() {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out
+}
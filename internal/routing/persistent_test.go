@@ -0,0 +1,211 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the Apache License, Version 2.0.
+package routing
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+)
+
+// fakeRecorder is a minimal events.Recorder that counts P2PConnected/P2PDisconnected transitions instead of
+// actually emitting Kubernetes events, so tests can assert on them directly.
+type fakeRecorder struct {
+	mx           sync.Mutex
+	connected    int
+	disconnected int
+}
+
+func (f *fakeRecorder) Connected() {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	f.connected++
+}
+
+func (f *fakeRecorder) Disconnected() {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	f.disconnected++
+}
+
+func (f *fakeRecorder) counts() (connected, disconnected int) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return f.connected, f.disconnected
+}
+
+// awaitConnectedness polls until h's connectedness to p matches want, or fails the test after a timeout.
+func awaitConnectedness(t *testing.T, h host.Host, p peer.ID, want network.Connectedness) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if h.Network().Connectedness(p) == want {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for connectedness %s to peer %s", want, p)
+}
+
+func TestPersistentPeerManagerReconnectsAfterDisconnect(t *testing.T) {
+	mn := mocknet.New()
+	t.Cleanup(func() { mn.Close() })
+
+	hostA, err := mn.GenPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostB, err := mn.GenPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := &fakeRecorder{}
+	peerB := peer.AddrInfo{ID: hostB.ID(), Addrs: hostB.Addrs()}
+	mgr := newPersistentPeerManager(hostA, rec, []peer.AddrInfo{peerB})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mgr.Start(ctx)
+
+	// Start's own connectWithBackoff should dial hostB, since LinkAll only made the two reachable without
+	// actually connecting them.
+	awaitConnectedness(t, hostA, hostB.ID(), network.Connected)
+
+	if err := mn.DisconnectPeers(hostA.ID(), hostB.ID()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Disconnected should fire on the drop, recording it and kicking off a reconnect; LinkAll left the two
+	// hosts reachable, so connectWithBackoff's retry should succeed without needing to wait out a full
+	// backoff cycle.
+	awaitConnectedness(t, hostA, hostB.ID(), network.Connected)
+
+	connected, disconnected := rec.counts()
+	if disconnected == 0 {
+		t.Error("expected Disconnected to have been recorded once the connection dropped")
+	}
+	if connected == 0 {
+		t.Error("expected Connected to have been recorded by the reconnect")
+	}
+}
+
+func TestHealthTrackerSort(t *testing.T) {
+	h := NewHealthTracker()
+
+	healthy := peer.ID("healthy")
+	slow := peer.ID("slow")
+	failing := peer.ID("failing")
+
+	h.RecordSuccess(healthy, 10*time.Millisecond)
+	h.RecordSuccess(slow, 100*time.Millisecond)
+	h.RecordFailure(failing)
+
+	infos := []PeerInfo{{ID: failing}, {ID: slow}, {ID: healthy}}
+	h.Sort(infos)
+
+	if infos[0].ID != healthy {
+		t.Errorf("expected healthy peer first, got %s", infos[0].ID)
+	}
+	if infos[1].ID != slow {
+		t.Errorf("expected slow peer second, got %s", infos[1].ID)
+	}
+	if infos[2].ID != failing {
+		t.Errorf("expected failing peer last, got %s", infos[2].ID)
+	}
+}
+
+func TestResolveHealthAwareStreamsBeforeInputCloses(t *testing.T) {
+	h := NewHealthTracker()
+
+	healthy := peer.ID("healthy")
+	failing := peer.ID("failing")
+	h.RecordSuccess(healthy, 10*time.Millisecond)
+	h.RecordFailure(failing)
+
+	in := make(chan PeerInfo, 2)
+	in <- PeerInfo{ID: failing}
+	in <- PeerInfo{ID: healthy}
+
+	out := ResolveHealthAware(context.Background(), in, h)
+
+	// The flush window is small relative to this timeout, so both peers, sorted healthy-first, should arrive
+	// well before the still-open input channel is ever closed.
+	select {
+	case p := <-out:
+		if p.ID != healthy {
+			t.Errorf("expected healthy peer first, got %s", p.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for ResolveHealthAware to flush without the input channel closing")
+	}
+
+	select {
+	case p := <-out:
+		if p.ID != failing {
+			t.Errorf("expected failing peer second, got %s", p.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the second peer")
+	}
+
+	close(in)
+}
+
+func TestResolveHealthAwareUnblocksOnCtxDone(t *testing.T) {
+	h := NewHealthTracker()
+
+	// Unbuffered so every send onto out blocks until read: if ResolveHealthAware didn't select on ctx.Done()
+	// around its sends, this goroutine would leak forever once the caller below stops reading.
+	in := make(chan PeerInfo)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	out := ResolveHealthAware(ctx, in, h)
+
+	in <- PeerInfo{ID: peer.ID("peer-1")}
+
+	// Give flush's timer a chance to fire and land on the blocked send before the caller abandons out.
+	time.Sleep(2 * healthAwareFlushWindow)
+	cancel()
+
+	// Whether or not the lone peer raced its way through before cancellation landed, out must end up closed
+	// shortly after: if ResolveHealthAware didn't select on ctx.Done() around its blocked send, it would hang
+	// forever and out would never close.
+	deadline := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-out:
+			if !ok {
+				return
+			}
+		case <-deadline:
+			t.Fatal("timed out waiting for ResolveHealthAware to close out after ctx.Done()")
+		}
+	}
+}
+
+func TestSplitAndTrim(t *testing.T) {
+	got := splitAndTrim(" /ip4/10.0.0.1/tcp/4001 , /ip4/10.0.0.2/tcp/4001,")
+	want := []string{"/ip4/10.0.0.1/tcp/4001", "/ip4/10.0.0.2/tcp/4001"}
+
+	if len(got) != len(want) {
+		t.Fatalf("expected %d addrs, got %d: %v", len(want), len(got), got)
+	}
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("expected %s, got %s", want[i], got[i])
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the Apache License, Version 2.0.
+package routing
+
+import (
+	"context"
+	"time"
+
+	"github.com/azure/peerd/pkg/peernet"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// PeerInfo describes a peer that can serve content for a given key.
+type PeerInfo struct {
+	// ID is the peer's libp2p identifier.
+	ID peer.ID
+
+	// Addr is the peer's IP address.
+	Addr string
+
+	// HttpHost is the address at which the peer's HTTP peer registry can be reached, e.g. "https://10.0.0.1:5000".
+	HttpHost string
+
+	// Health is the most recently observed connection quality for this peer, if any has been recorded.
+	Health PeerHealth
+}
+
+// PeerHealth summarizes the connection quality observed for a peer, so that Resolve can prefer healthy
+// peers over ones that have recently failed or are slow to respond.
+type PeerHealth struct {
+	// RTT is the most recently observed round-trip time to the peer.
+	RTT time.Duration
+
+	// Failures is the number of consecutive PreadRemote/FstatRemote failures observed for the peer.
+	Failures int
+
+	// LastSeen is when the peer was last observed to be reachable.
+	LastSeen time.Time
+}
+
+// Router resolves keys to the peers that can serve them, and advertises the keys this node can serve.
+type Router interface {
+	// Net returns the underlying peer network.
+	Net() peernet.Network
+
+	// Resolve finds peers that can serve the given key.
+	Resolve(ctx context.Context, key string, allowSelf bool, count int) (<-chan PeerInfo, error)
+
+	// ResolveWithCache is like Resolve, but also returns a callback that the caller should invoke if the
+	// resolved peers turn out to be unusable, so the key can be negatively cached.
+	ResolveWithCache(ctx context.Context, key string, allowSelf bool, count int) (<-chan PeerInfo, func(), error)
+
+	// Advertise announces that this node can serve the given keys.
+	Advertise(ctx context.Context, keys []string) error
+
+	// Subscribe streams a PeerEvent for every add or remove of a provider of one of keys, so that callers
+	// don't have to re-Resolve to notice a change.
+	Subscribe(ctx context.Context, keys []string) (<-chan PeerEvent, error)
+
+	// Close shuts down the router.
+	Close() error
+}
@@ -0,0 +1,416 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the Apache License, Version 2.0.
+package routing
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/rs/zerolog/log"
+)
+
+// SubscribeProtocolID is the libp2p stream protocol used to push provider-set changes to subscribed peers,
+// so that a Subscribe caller learns about a flapping peer without having to poll Resolve again.
+const SubscribeProtocolID protocol.ID = "/peerd/subscribe/1.0.0"
+
+// PeerEventType describes the kind of change a PeerEvent reports.
+type PeerEventType int
+
+const (
+	// PeerEventAdded reports that a peer started providing a key.
+	PeerEventAdded PeerEventType = iota
+
+	// PeerEventRemoved reports that a peer stopped providing a key.
+	PeerEventRemoved
+)
+
+func (t PeerEventType) String() string {
+	switch t {
+	case PeerEventAdded:
+		return "added"
+	case PeerEventRemoved:
+		return "removed"
+	default:
+		return "unknown"
+	}
+}
+
+// PeerEvent reports that the set of providers for Key changed by the addition or removal of Peer.
+type PeerEvent struct {
+	Type PeerEventType
+	Key  string
+	Peer PeerInfo
+}
+
+// subscribeMessage is the wire format pushed to subscribers over SubscribeProtocolID.
+type subscribeMessage struct {
+	Type PeerEventType `json:"type"`
+	Key  string        `json:"key"`
+	Peer PeerInfo      `json:"peer"`
+}
+
+// subscriptionIndex is a consumer-side, in-memory index, keyed by key, of the peers a Subscribe caller has
+// been told about. It fans incoming PeerEvents out to every channel registered for the affected key, and
+// invalidates the corresponding lookupCache entry so a concurrent Resolve doesn't return a stale result.
+type subscriptionIndex struct {
+	mx    sync.RWMutex
+	byKey map[string]map[peer.ID]PeerInfo
+	subs  map[string][]chan PeerEvent
+}
+
+// newSubscriptionIndex creates an empty subscriptionIndex.
+func newSubscriptionIndex() *subscriptionIndex {
+	return &subscriptionIndex{
+		byKey: map[string]map[peer.ID]PeerInfo{},
+		subs:  map[string][]chan PeerEvent{},
+	}
+}
+
+// subscribe registers a new channel that receives PeerEvents for any of keys, and returns an unsubscribe
+// function that drops the channel from every key's subscriber list. unsubscribe does not close the channel:
+// apply delivers to a snapshot of subs taken under s.mx, so closing here could race a send that snapshot
+// already committed to; it's left to the garbage collector once the caller drops its reference.
+func (s *subscriptionIndex) subscribe(keys []string) (<-chan PeerEvent, func()) {
+	ch := make(chan PeerEvent, 16)
+
+	s.mx.Lock()
+	for _, k := range keys {
+		s.subs[k] = append(s.subs[k], ch)
+	}
+	s.mx.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			s.mx.Lock()
+			defer s.mx.Unlock()
+			for _, k := range keys {
+				subs := s.subs[k]
+				for i, c := range subs {
+					if c == ch {
+						s.subs[k] = append(subs[:i], subs[i+1:]...)
+						break
+					}
+				}
+			}
+		})
+	}
+
+	return ch, unsubscribe
+}
+
+// apply records evt against the index and delivers it to every channel subscribed to evt.Key, invalidating
+// the resolve cache entry for evt.Key via invalidate first so a racing Resolve never observes the old state
+// after the event has already been delivered.
+func (s *subscriptionIndex) apply(evt PeerEvent, invalidate func(key string)) {
+	s.mx.Lock()
+	peers, ok := s.byKey[evt.Key]
+	if !ok {
+		peers = map[peer.ID]PeerInfo{}
+		s.byKey[evt.Key] = peers
+	}
+
+	switch evt.Type {
+	case PeerEventAdded:
+		peers[evt.Peer.ID] = evt.Peer
+	case PeerEventRemoved:
+		delete(peers, evt.Peer.ID)
+	}
+
+	subs := append([]chan PeerEvent{}, s.subs[evt.Key]...)
+	s.mx.Unlock()
+
+	invalidate(evt.Key)
+
+	for _, ch := range subs {
+		select {
+		case ch <- evt:
+		default:
+			log.Warn().Str("key", evt.Key).Stringer("type", evt.Type).Msg("dropping peer event: subscriber channel is full")
+		}
+	}
+}
+
+// handleStream reads subscribeMessages pushed by a provider over a SubscribeProtocolID stream and applies
+// them to the index until the stream is closed or an event fails to decode.
+func (s *subscriptionIndex) handleStream(str network.Stream, invalidate func(key string)) {
+	defer str.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(str))
+	for {
+		var msg subscribeMessage
+		if err := dec.Decode(&msg); err != nil {
+			return
+		}
+
+		s.apply(PeerEvent{Type: msg.Type, Key: msg.Key, Peer: msg.Peer}, invalidate)
+	}
+}
+
+// subscriber tracks which keys a remote peer has asked to be notified about, and the open stream to push
+// those notifications over. writeMx serializes writes to stream across broadcasts, since a new goroutine is
+// spawned per event batch and libp2p streams aren't safe for concurrent writers.
+type subscriber struct {
+	keys    []string
+	stream  network.Stream
+	writeMx sync.Mutex
+}
+
+// addrChangeNotifier watches the local host's libp2p event bus for address changes, and pushes the actual
+// add/remove delta to every peer that is subscribed to a key this node provides, so that a flapping node is
+// reflected at consumers without them needing to re-Resolve.
+type addrChangeNotifier struct {
+	host host.Host
+
+	mx          sync.RWMutex
+	subscribers map[peer.ID]*subscriber
+	prevAddrs   map[string]struct{}
+
+	providedKeys func() []string
+}
+
+// newAddrChangeNotifier subscribes to the host's local-address-change events and returns a notifier that
+// pushes updates to registered subscribers whenever they fire.
+func newAddrChangeNotifier(h host.Host, providedKeys func() []string) (*addrChangeNotifier, error) {
+	n := &addrChangeNotifier{
+		host:         h,
+		subscribers:  map[peer.ID]*subscriber{},
+		prevAddrs:    map[string]struct{}{},
+		providedKeys: providedKeys,
+	}
+
+	for _, a := range h.Addrs() {
+		n.prevAddrs[a.String()] = struct{}{}
+	}
+
+	sub, err := h.EventBus().Subscribe(new(event.EvtLocalAddressesUpdated))
+	if err != nil {
+		return nil, err
+	}
+
+	go n.watch(sub)
+
+	return n, nil
+}
+
+// watch reads address-change events off sub for the lifetime of the notifier and broadcasts each one.
+func (n *addrChangeNotifier) watch(sub event.Subscription) {
+	defer sub.Close()
+	for range sub.Out() {
+		n.broadcastSelf()
+	}
+}
+
+// broadcastSelf diffs the host's current addresses against the last observed set, and pushes the resulting
+// add/remove PeerEvents, one per provided key, to every registered subscriber that asked for that key. Each subscriber is written to
+// from its own goroutine so that one slow or blocked peer can't stall registration or the other pushes.
+func (n *addrChangeNotifier) broadcastSelf() {
+	current := map[string]struct{}{}
+	for _, a := range n.host.Addrs() {
+		current[a.String()] = struct{}{}
+	}
+
+	n.mx.Lock()
+	prev := n.prevAddrs
+	n.prevAddrs = current
+	subs := make(map[peer.ID]*subscriber, len(n.subscribers))
+	for p, sub := range n.subscribers {
+		subs[p] = sub
+	}
+	n.mx.Unlock()
+
+	self := n.host.ID()
+	keys := n.providedKeys()
+
+	var events []subscribeMessage
+	for addr := range current {
+		if _, ok := prev[addr]; !ok {
+			for _, key := range keys {
+				events = append(events, subscribeMessage{Type: PeerEventAdded, Key: key, Peer: PeerInfo{ID: self, Addr: addr}})
+			}
+		}
+	}
+	for addr := range prev {
+		if _, ok := current[addr]; !ok {
+			for _, key := range keys {
+				events = append(events, subscribeMessage{Type: PeerEventRemoved, Key: key, Peer: PeerInfo{ID: self, Addr: addr}})
+			}
+		}
+	}
+
+	if len(events) == 0 {
+		return
+	}
+
+	for p, sub := range subs {
+		p, sub := p, sub
+		go func() {
+			wanted := make(map[string]struct{}, len(sub.keys))
+			for _, k := range sub.keys {
+				wanted[k] = struct{}{}
+			}
+
+			sub.writeMx.Lock()
+			defer sub.writeMx.Unlock()
+
+			enc := json.NewEncoder(sub.stream)
+			for _, evt := range events {
+				if _, ok := wanted[evt.Key]; !ok {
+					continue
+				}
+				if err := enc.Encode(evt); err != nil {
+					log.Warn().Err(err).Str("peer", p.String()).Msg("failed to push peer event")
+					return
+				}
+			}
+		}()
+	}
+}
+
+// register records that p opened a SubscribeProtocolID stream for keys, and should receive future address
+// change notifications for those keys over str.
+func (n *addrChangeNotifier) register(p peer.ID, keys []string, str network.Stream) {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+	n.subscribers[p] = &subscriber{keys: keys, stream: str}
+}
+
+// unregister drops p, e.g. once its subscribe stream is closed or reset.
+func (n *addrChangeNotifier) unregister(p peer.ID) {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+	delete(n.subscribers, p)
+}
+
+// subscribeRequest is the first message sent on a SubscribeProtocolID stream, naming the keys the opener
+// wants to be notified about.
+type subscribeRequest struct {
+	Keys []string `json:"keys"`
+}
+
+// SubscriptionManager implements both sides of the Subscribe protocol: as a provider it answers other peers'
+// subscribe requests by registering them with addrChangeNotifier so they're pushed this node's address
+// changes; as a consumer, Subscribe opens streams to the peers that provide a key and feeds what they push
+// into subscriptionIndex, invalidating the resolve cache and emitting the delta to the caller.
+type SubscriptionManager struct {
+	host       host.Host
+	notifier   *addrChangeNotifier
+	index      *subscriptionIndex
+	invalidate func(key string)
+}
+
+// NewSubscriptionManager creates a SubscriptionManager. providedKeys reports the keys this node currently
+// advertises, and invalidate is called with a key whenever a subscribed peer's provider status for it
+// changes, so a concrete router can drop the matching lookupCache entry.
+func NewSubscriptionManager(h host.Host, providedKeys func() []string, invalidate func(key string)) (*SubscriptionManager, error) {
+	n, err := newAddrChangeNotifier(h, providedKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SubscriptionManager{
+		host:       h,
+		notifier:   n,
+		index:      newSubscriptionIndex(),
+		invalidate: invalidate,
+	}, nil
+}
+
+// RegisterStreamHandler installs the SubscribeProtocolID handler that answers incoming subscribe requests
+// from other peers who want to be notified of this node's address changes.
+func (m *SubscriptionManager) RegisterStreamHandler() {
+	m.host.SetStreamHandler(SubscribeProtocolID, func(s network.Stream) {
+		var req subscribeRequest
+		if err := json.NewDecoder(bufio.NewReader(s)).Decode(&req); err != nil {
+			s.Close()
+			return
+		}
+
+		remote := s.Conn().RemotePeer()
+		m.notifier.register(remote, req.Keys, s)
+
+		go func() {
+			defer m.notifier.unregister(remote)
+			defer s.Close()
+			_, _ = io.Copy(io.Discard, s)
+		}()
+	})
+}
+
+// Subscribe implements the consumer side: it opens a SubscribeProtocolID stream to each of peers, registers
+// interest in keys on each, and streams back a PeerEvent for every add/remove pushed over any of those
+// streams, invalidating the resolve cache for the affected key as each one arrives. Once ctx is done, every
+// open stream is closed (unblocking handleStream's decode loop) and the caller's channel is dropped from the
+// index, so abandoning a Subscribe doesn't leak a stream, a handleStream goroutine, and a subs entry forever.
+func (m *SubscriptionManager) Subscribe(ctx context.Context, keys []string, peers []peer.ID) <-chan PeerEvent {
+	ch, unsubscribe := m.index.subscribe(keys)
+
+	var mx sync.Mutex
+	var streams []network.Stream
+	done := false
+
+	track := func(s network.Stream) bool {
+		mx.Lock()
+		defer mx.Unlock()
+		if done {
+			return false
+		}
+		streams = append(streams, s)
+		return true
+	}
+
+	var wg sync.WaitGroup
+	for _, p := range peers {
+		p := p
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			s, err := m.host.NewStream(ctx, p, SubscribeProtocolID)
+			if err != nil {
+				log.Debug().Err(err).Str("peer", p.String()).Msg("failed to open subscribe stream")
+				return
+			}
+
+			if err := json.NewEncoder(s).Encode(subscribeRequest{Keys: keys}); err != nil {
+				s.Close()
+				return
+			}
+
+			if !track(s) {
+				s.Close()
+				return
+			}
+
+			m.index.handleStream(s, m.invalidate)
+		}()
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		mx.Lock()
+		done = true
+		toClose := streams
+		mx.Unlock()
+
+		for _, s := range toClose {
+			s.Close()
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		unsubscribe()
+	}()
+
+	return ch
+}
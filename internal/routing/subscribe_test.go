@@ -0,0 +1,195 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the Apache License, Version 2.0.
+package routing
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+)
+
+// newLinkedSubscriptionManagerPair builds a provider/consumer SubscriptionManager pair on two connected
+// mocknet hosts, with the provider's handler already registered, so tests can drive the real
+// addrChangeNotifier/SubscriptionManager protocol code instead of just subscriptionIndex.
+func newLinkedSubscriptionManagerPair(t *testing.T, providedKeys func() []string, invalidate func(string)) (provider, consumer *SubscriptionManager, providerHost, consumerHost host.Host) {
+	t.Helper()
+
+	mn := mocknet.New()
+	t.Cleanup(func() { mn.Close() })
+
+	ph, err := mn.GenPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch, err := mn.GenPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mn.ConnectAllButSelf(); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewSubscriptionManager(ph, providedKeys, func(string) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.RegisterStreamHandler()
+
+	c, err := NewSubscriptionManager(ch, func() []string { return nil }, invalidate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p, c, ph, ch
+}
+
+// awaitProviderSubscriberCount polls until the provider's notifier has exactly n registered subscribers, or
+// fails the test after a timeout.
+func awaitProviderSubscriberCount(t *testing.T, provider *SubscriptionManager, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		provider.notifier.mx.RLock()
+		got := len(provider.notifier.subscribers)
+		provider.notifier.mx.RUnlock()
+		if got == n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for provider to have %d subscriber(s)", n)
+}
+
+func TestSubscriptionManagerPushesAddrChanges(t *testing.T) {
+	var mx sync.Mutex
+	var invalidated []string
+
+	provider, consumer, providerHost, _ := newLinkedSubscriptionManagerPair(t, func() []string { return []string{"key-1"} }, func(key string) {
+		mx.Lock()
+		defer mx.Unlock()
+		invalidated = append(invalidated, key)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch := consumer.Subscribe(ctx, []string{"key-1"}, []peer.ID{providerHost.ID()})
+	awaitProviderSubscriberCount(t, provider, 1)
+
+	// Simulate an address change: pretend the provider used to have an extra address that's now gone, so
+	// broadcastSelf has a real diff to report instead of a no-op.
+	const staleAddr = "/ip4/10.0.0.9/tcp/4001"
+	provider.notifier.mx.Lock()
+	provider.notifier.prevAddrs[staleAddr] = struct{}{}
+	provider.notifier.mx.Unlock()
+	provider.notifier.broadcastSelf()
+
+	select {
+	case evt := <-ch:
+		if evt.Type != PeerEventRemoved || evt.Key != "key-1" {
+			t.Errorf("unexpected event: %+v", evt)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for peer event")
+	}
+
+	mx.Lock()
+	got := append([]string{}, invalidated...)
+	mx.Unlock()
+	if len(got) != 1 || got[0] != "key-1" {
+		t.Errorf("expected key-1 to be invalidated, got %v", got)
+	}
+}
+
+func TestSubscriptionManagerSubscribeUnregistersOnCtxDone(t *testing.T) {
+	provider, consumer, providerHost, _ := newLinkedSubscriptionManagerPair(t, func() []string { return []string{"key-1"} }, func(string) {})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	consumer.Subscribe(ctx, []string{"key-1"}, []peer.ID{providerHost.ID()})
+	awaitProviderSubscriberCount(t, provider, 1)
+
+	// Cancelling ctx should close the consumer's end of the stream, which unblocks the provider's
+	// io.Copy(io.Discard, s) read loop and lets it unregister the subscriber — proving the handler goroutine
+	// actually exits instead of leaking the stream forever.
+	cancel()
+	awaitProviderSubscriberCount(t, provider, 0)
+}
+
+func TestSubscriptionIndexApply(t *testing.T) {
+	idx := newSubscriptionIndex()
+	ch, _ := idx.subscribe([]string{"key-1"})
+
+	var invalidated []string
+	invalidate := func(key string) { invalidated = append(invalidated, key) }
+
+	p := PeerInfo{ID: peer.ID("peer-1"), Addr: "10.0.0.1"}
+	idx.apply(PeerEvent{Type: PeerEventAdded, Key: "key-1", Peer: p}, invalidate)
+
+	select {
+	case evt := <-ch:
+		if evt.Type != PeerEventAdded || evt.Key != "key-1" || evt.Peer.ID != p.ID {
+			t.Errorf("unexpected event: %+v", evt)
+		}
+	default:
+		t.Fatal("expected an event")
+	}
+
+	if len(invalidated) != 1 || invalidated[0] != "key-1" {
+		t.Errorf("expected key-1 to be invalidated, got %v", invalidated)
+	}
+
+	idx.mx.RLock()
+	if _, ok := idx.byKey["key-1"][p.ID]; !ok {
+		t.Error("expected peer to be recorded in the index")
+	}
+	idx.mx.RUnlock()
+
+	idx.apply(PeerEvent{Type: PeerEventRemoved, Key: "key-1", Peer: p}, invalidate)
+
+	idx.mx.RLock()
+	if _, ok := idx.byKey["key-1"][p.ID]; ok {
+		t.Error("expected peer to be removed from the index")
+	}
+	idx.mx.RUnlock()
+}
+
+func TestSubscriptionIndexIgnoresUnsubscribedKeys(t *testing.T) {
+	idx := newSubscriptionIndex()
+	ch, _ := idx.subscribe([]string{"key-1"})
+
+	idx.apply(PeerEvent{Type: PeerEventAdded, Key: "key-2", Peer: PeerInfo{ID: peer.ID("peer-1")}}, func(string) {})
+
+	select {
+	case evt := <-ch:
+		t.Fatalf("expected no event for unsubscribed key, got %+v", evt)
+	default:
+	}
+}
+
+func TestSubscriptionIndexUnsubscribeRemovesChannel(t *testing.T) {
+	idx := newSubscriptionIndex()
+	_, unsubscribe := idx.subscribe([]string{"key-1"})
+
+	idx.mx.RLock()
+	if len(idx.subs["key-1"]) != 1 {
+		t.Fatalf("expected one subscriber for key-1, got %d", len(idx.subs["key-1"]))
+	}
+	idx.mx.RUnlock()
+
+	unsubscribe()
+
+	idx.mx.RLock()
+	defer idx.mx.RUnlock()
+	if len(idx.subs["key-1"]) != 0 {
+		t.Errorf("expected unsubscribe to remove the channel, got %d left", len(idx.subs["key-1"]))
+	}
+}
@@ -18,6 +18,7 @@ type MockRouter struct {
 	resolver map[string][]string
 
 	negCache map[string]struct{}
+	subs     map[string][]chan routing.PeerEvent
 }
 
 // Net implements routing.Router.
@@ -47,6 +48,7 @@ func NewMockRouter(resolver map[string][]string) *MockRouter {
 		net:      n,
 		resolver: resolver,
 		negCache: map[string]struct{}{},
+		subs:     map[string][]chan routing.PeerEvent{},
 	}
 }
 
@@ -79,10 +81,31 @@ func (m *MockRouter) Advertise(ctx context.Context, keys []string) error {
 	defer m.mx.Unlock()
 	for _, key := range keys {
 		m.resolver[key] = []string{"localhost"}
+
+		evt := routing.PeerEvent{Type: routing.PeerEventAdded, Key: key, Peer: routing.PeerInfo{ID: peer.ID("localhost"), Addr: "localhost"}}
+		for _, ch := range m.subs[key] {
+			select {
+			case ch <- evt:
+			default:
+			}
+		}
 	}
 	return nil
 }
 
+// Subscribe implements Router.
+func (m *MockRouter) Subscribe(ctx context.Context, keys []string) (<-chan routing.PeerEvent, error) {
+	ch := make(chan routing.PeerEvent, 1)
+
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	for _, key := range keys {
+		m.subs[key] = append(m.subs[key], ch)
+	}
+
+	return ch, nil
+}
+
 func (m *MockRouter) LookupKey(key string) ([]string, bool) {
 	m.mx.RLock()
 	defer m.mx.RUnlock()
@@ -3,62 +3,63 @@
 package cache
 
 import (
-	"sync"
-)
+	lru "github.com/hashicorp/golang-lru/arc/v2"
 
-const defaultEvictionPercentage int = 5 // The default eviction percentage. Used when the map reaches its capacity at insertion.
+	"github.com/azure/peerd/pkg/metrics"
+)
 
-// SyncMap is a map that can be safely accessed concurrently.
+// SyncMap is a map that can be safely accessed concurrently. Entries are held in an Adaptive Replacement
+// Cache (ARC), which tracks a recency list and a frequency list, plus their ghost lists, and adapts the
+// balance between them based on which ghost list a key lands in. This keeps hot keys from being evicted by a
+// burst of cold, one-off keys the way plain map-iteration-order eviction would.
+//
+// SyncMap used to take an evictionPercentage knob and evict that fraction of entries at once, in map
+// iteration order, whenever it was at capacity. That's deliberately gone: map iteration order has no
+// relationship to the ARC's recency/frequency ordering, so a batch evicting this way could just as easily
+// sweep out hot T2 keys as cold ones, defeating the point of using an ARC at all. Set now lets the ARC evict
+// a single entry itself, per its own recency/frequency ordering, on every Add past capacity.
 type SyncMap struct {
-	mapObj             *map[string]interface{}
-	lock               *sync.RWMutex
-	capacity           int
-	evictionPercentage int
+	arc *lru.ARCCache[string, interface{}]
 }
 
 // Get retrieves the value associated with the given key from the SyncMap.
 // It returns the value and a boolean indicating whether the key was found.
 func (sm *SyncMap) Get(key string) (entry interface{}, ok bool) {
-	sm.lock.RLock()
-	defer sm.lock.RUnlock()
-	entry, ok = (*sm.mapObj)[key]
+	entry, ok = sm.arc.Get(key)
+	if ok {
+		metrics.CacheHits.Inc()
+	} else {
+		metrics.CacheMisses.Inc()
+	}
 	return
 }
 
+// Peek retrieves the value associated with the given key without affecting its recency or frequency, so
+// callers can inspect the cache without influencing what gets evicted next.
+func (sm *SyncMap) Peek(key string) (entry interface{}, ok bool) {
+	return sm.arc.Peek(key)
+}
+
 // Set sets a new entry or updates an existing one.
 // Set adds or updates an entry in the SyncMap with the specified key.
 // If the key already exists in the map, the entry will be updated.
-// If the key does not exist and the map is at capacity, some entries will be evicted first.
+// If the key does not exist and the map is at capacity, the ARC evicts a single entry from whichever of its
+// recency/frequency lists its adaptive target currently favors.
 func (sm *SyncMap) Set(key string, entry interface{}) {
-	sm.lock.Lock()
-	defer sm.lock.Unlock()
+	before := sm.arc.Len()
+	_, existed := sm.arc.Peek(key)
 
-	if _, ok := (*sm.mapObj)[key]; !ok {
-		if numEntries := len(*sm.mapObj); numEntries >= sm.capacity {
-			numToEvict := numEntries * sm.evictionPercentage / 100
-			if numToEvict <= 1 {
-				numToEvict = 1
-			}
-			numEvicted := 0
-			for k := range *sm.mapObj {
-				delete(*sm.mapObj, k)
-				numEvicted++
-				if numEvicted >= numToEvict {
-					break
-				}
-			}
-		}
-	}
+	sm.arc.Add(key, entry)
 
-	(*sm.mapObj)[key] = entry
+	if !existed && sm.arc.Len() <= before {
+		metrics.CacheEvictions.Inc()
+	}
 }
 
 // Delete removes the entry with the specified key from the SyncMap.
 // If the key does not exist, this method does nothing.
 func (sm *SyncMap) Delete(key string) {
-	sm.lock.Lock()
-	defer sm.lock.Unlock()
-	delete(*sm.mapObj, key)
+	sm.arc.Remove(key)
 }
 
 // NewSyncMap creates a new SyncMap with the specified maximum number of entries.
@@ -67,8 +68,12 @@ func NewSyncMap(maxEntries int) *SyncMap {
 	if maxEntries <= 0 {
 		maxEntries = 1
 	}
-	return &SyncMap{mapObj: &map[string]interface{}{},
-		lock:               &sync.RWMutex{},
-		capacity:           maxEntries,
-		evictionPercentage: defaultEvictionPercentage}
+
+	arc, err := lru.NewARC[string, interface{}](maxEntries)
+	if err != nil {
+		// NewARC only errors on a non-positive size, which maxEntries can no longer be at this point.
+		panic(err)
+	}
+
+	return &SyncMap{arc: arc}
 }
@@ -0,0 +1,142 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package cache
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/azure/peerd/pkg/metrics"
+)
+
+func TestSyncMapGetSetDelete(t *testing.T) {
+	sm := NewSyncMap(10)
+
+	if _, ok := sm.Get("a"); ok {
+		t.Fatal("expected a miss on an empty map")
+	}
+
+	sm.Set("a", 1)
+	v, ok := sm.Get("a")
+	if !ok || v != 1 {
+		t.Fatalf("expected a=1, got %v, %v", v, ok)
+	}
+
+	sm.Delete("a")
+	if _, ok := sm.Get("a"); ok {
+		t.Error("expected a to be gone after Delete")
+	}
+}
+
+func TestSyncMapPeekDoesNotAffectRecency(t *testing.T) {
+	sm := NewSyncMap(2)
+
+	sm.Set("a", 1)
+	sm.Set("b", 2)
+
+	// Peeking "a" should not count as a use: it should still be the least recently used entry, and adding a
+	// third key should evict it rather than "b".
+	if v, ok := sm.Peek("a"); !ok || v != 1 {
+		t.Fatalf("expected to peek a=1, got %v, %v", v, ok)
+	}
+
+	sm.Set("c", 3)
+
+	if _, ok := sm.Get("a"); ok {
+		t.Error("expected a to be evicted despite being peeked, since Peek shouldn't bump its recency")
+	}
+	if _, ok := sm.Get("b"); !ok {
+		t.Error("expected b to survive eviction")
+	}
+	if _, ok := sm.Get("c"); !ok {
+		t.Error("expected c to have been added")
+	}
+}
+
+func TestSyncMapEvictsAtCapacity(t *testing.T) {
+	sm := NewSyncMap(4)
+
+	for i := 0; i < 8; i++ {
+		sm.Set(string(rune('a'+i)), i)
+	}
+
+	n := 0
+	for i := 0; i < 8; i++ {
+		if _, ok := sm.Get(string(rune('a' + i))); ok {
+			n++
+		}
+	}
+
+	if n == 0 || n > 4 {
+		t.Errorf("expected between 1 and capacity (4) surviving entries, got %d", n)
+	}
+}
+
+func TestSyncMapHotKeySurvivesColdBurst(t *testing.T) {
+	sm := NewSyncMap(4)
+
+	sm.Set("hot", 1)
+
+	// Get "hot" repeatedly so the ARC promotes it from T1 (recency) into T2 (frequency).
+	for i := 0; i < 10; i++ {
+		if _, ok := sm.Get("hot"); !ok {
+			t.Fatal("expected hot to still be present while warming it up")
+		}
+	}
+
+	// A burst of cold, one-off keys well beyond capacity should only ever evict from T1, never touch "hot"
+	// in T2: this is the whole point of the ARC migration over the old map-iteration-order eviction.
+	for i := 0; i < 50; i++ {
+		sm.Set(string(rune('a'+i%26))+string(rune('A'+i/26)), i)
+	}
+
+	if _, ok := sm.Get("hot"); !ok {
+		t.Error("expected hot to survive a burst of cold keys, since it was promoted to the frequency list")
+	}
+}
+
+func TestSyncMapCounters(t *testing.T) {
+	sm := NewSyncMap(1)
+
+	hitsBefore := testutil.ToFloat64(metrics.CacheHits)
+	missesBefore := testutil.ToFloat64(metrics.CacheMisses)
+	evictionsBefore := testutil.ToFloat64(metrics.CacheEvictions)
+
+	sm.Set("a", 1)
+	if _, ok := sm.Get("a"); !ok {
+		t.Fatal("expected a hit")
+	}
+	if _, ok := sm.Get("missing"); ok {
+		t.Fatal("expected a miss")
+	}
+	sm.Set("b", 2) // capacity is 1, so this evicts "a".
+
+	if got := testutil.ToFloat64(metrics.CacheHits) - hitsBefore; got != 1 {
+		t.Errorf("expected CacheHits to increase by 1, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.CacheMisses) - missesBefore; got != 1 {
+		t.Errorf("expected CacheMisses to increase by 1, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.CacheEvictions) - evictionsBefore; got < 1 {
+		t.Errorf("expected CacheEvictions to increase by at least 1, got %v", got)
+	}
+}
+
+func TestNewSyncMapClampsNonPositiveSize(t *testing.T) {
+	sm := NewSyncMap(0)
+
+	sm.Set("a", 1)
+	sm.Set("b", 2)
+
+	n := 0
+	for _, k := range []string{"a", "b"} {
+		if _, ok := sm.Get(k); ok {
+			n++
+		}
+	}
+
+	if n != 1 {
+		t.Errorf("expected a maxEntries<=0 map to be clamped to size 1, got %d entries", n)
+	}
+}
@@ -0,0 +1,326 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package routing
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/azure/peerd/internal/k8s/events"
+	ir "github.com/azure/peerd/internal/routing"
+	"github.com/azure/peerd/pkg/k8s"
+	"github.com/azure/peerd/pkg/peernet"
+	"github.com/dgraph-io/ristretto"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	corerouting "github.com/libp2p/go-libp2p/core/routing"
+	"github.com/libp2p/go-libp2p/p2p/discovery/routing"
+	multiaddr "github.com/multiformats/go-multiaddr"
+)
+
+// strPeerNotFound is the lookupCache value written for a key that a ResolveWithNegativeCacheCallback caller
+// reported as having no usable provider, so a subsequent Resolve for the same key can short-circuit.
+const strPeerNotFound = "peer not found"
+
+// negativeCacheCost is the ristretto cost charged for a single negative-cache entry; entries are tiny
+// strings, so every one is weighted equally.
+const negativeCacheCost = 1
+
+// router is the production Router: it resolves and advertises content through a libp2p content routing
+// discovery mechanism backed by a Kubernetes-aware ContentRouting implementation, augmented with a delegated
+// HTTP lookup (ResolveWithHttp), and negatively caches keys that turn out to have no reachable provider.
+type router struct {
+	k8sClient        *k8s.ClientSet
+	host             host.Host
+	peerRegistryPort string
+	lookupCache      *ristretto.Cache
+
+	content *routing.RoutingDiscovery
+	net     peernet.Network
+
+	// httpRouter races a delegated HTTP lookup alongside content's libp2p lookup in Resolve; it is nil if no
+	// delegated routing endpoints were configured, in which case Resolve falls back to the libp2p path alone.
+	httpRouter *ir.HttpRouter
+
+	// health orders Resolve's results by observed peer responsiveness; it is nil for the router literal
+	// built directly in tests, in which case Resolve skips health-aware ordering.
+	health *ir.HealthTracker
+
+	// advertised tracks the keys Provide has been called with, so Handler's delegated routing HttpServer
+	// only answers lookups for content this node actually serves.
+	advertised *advertisedKeys
+
+	// subs implements Subscribe's push-based notifications, both answering other peers' subscribe requests
+	// for this node's address changes and, as a consumer, opening subscribe streams to the peers Subscribe
+	// resolves.
+	subs *ir.SubscriptionManager
+
+	// cancel stops the persistentPeerManager goroutines StartPersistentPeers started, if any were; it is a
+	// no-op func if no persistent peers were configured. Close calls it so reconnect attempts don't outlive
+	// the router.
+	cancel context.CancelFunc
+}
+
+// ensure router satisfies ir.Router; this catches at compile time if a method is missed or its signature
+// drifts, since nothing else in this package requires it to.
+var _ ir.Router = &router{}
+
+// advertisedKeys is a concurrency-safe set of the keys this node has advertised.
+type advertisedKeys struct {
+	mx   sync.RWMutex
+	keys map[string]struct{}
+}
+
+func newAdvertisedKeys() *advertisedKeys {
+	return &advertisedKeys{keys: map[string]struct{}{}}
+}
+
+func (a *advertisedKeys) add(keys []string) {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	for _, k := range keys {
+		a.keys[k] = struct{}{}
+	}
+}
+
+func (a *advertisedKeys) has(key string) bool {
+	a.mx.RLock()
+	defer a.mx.RUnlock()
+	_, ok := a.keys[key]
+	return ok
+}
+
+// list returns every key currently advertised.
+func (a *advertisedKeys) list() []string {
+	a.mx.RLock()
+	defer a.mx.RUnlock()
+	keys := make([]string, 0, len(a.keys))
+	for k := range a.keys {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// ipv4HttpHost returns the first IPv4 value found among addrs, alongside the https HttpHost built from it
+// and port, or ("", "", false) if none of addrs carry an IPv4 component.
+func ipv4HttpHost(addrs []multiaddr.Multiaddr, port string) (addr, httpHost string, ok bool) {
+	for _, a := range addrs {
+		if v, err := a.ValueForProtocol(multiaddr.P_IP4); err == nil {
+			return v, fmt.Sprintf("https://%s:%s", v, port), true
+		}
+	}
+	return "", "", false
+}
+
+// Resolve finds peers that can serve key: content's libp2p discovery lookup, raced against r.httpRouter's
+// delegated HTTP lookup if one is configured, and then, if r.health is set, re-ordered so the most
+// responsive peers are streamed first. If key was previously reported unusable via
+// ResolveWithNegativeCacheCallback and hasn't since been re-advertised, Resolve short-circuits with an
+// already-closed, empty channel instead of paying for another FindPeers lookup.
+func (r *router) Resolve(ctx context.Context, key string, allowSelf bool, count int) (<-chan ir.PeerInfo, error) {
+	if v, ok := r.lookupCache.Get(key); ok && v == strPeerNotFound {
+		empty := make(chan ir.PeerInfo)
+		close(empty)
+		return empty, nil
+	}
+
+	addrs, err := r.content.FindPeers(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	libp2p := make(chan ir.PeerInfo, count)
+	go func() {
+		defer close(libp2p)
+
+		for ai := range addrs {
+			if !allowSelf && ai.ID == r.host.ID() {
+				continue
+			}
+
+			pi := ir.PeerInfo{ID: ai.ID}
+			pi.Addr, pi.HttpHost, _ = ipv4HttpHost(ai.Addrs, r.peerRegistryPort)
+
+			select {
+			case libp2p <- pi:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	merged := ir.ResolveWithHttp(ctx, key, libp2p, r.httpRouter)
+	if r.health == nil {
+		return merged, nil
+	}
+
+	return ir.ResolveHealthAware(ctx, merged, r.health), nil
+}
+
+// ResolveWithNegativeCacheCallback is like Resolve, but also returns a callback the caller should invoke if
+// the resolved peers turn out to be unusable, so key is written to lookupCache as strPeerNotFound and a
+// subsequent Resolve for it can short-circuit.
+func (r *router) ResolveWithNegativeCacheCallback(ctx context.Context, key string, allowSelf bool, count int) (<-chan ir.PeerInfo, func(), error) {
+	out, err := r.Resolve(ctx, key, allowSelf, count)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return out, func() { r.lookupCache.Set(key, strPeerNotFound, negativeCacheCost) }, nil
+}
+
+// ResolveWithCache implements ir.Router; it's just ResolveWithNegativeCacheCallback under the name the
+// interface expects.
+func (r *router) ResolveWithCache(ctx context.Context, key string, allowSelf bool, count int) (<-chan ir.PeerInfo, func(), error) {
+	return r.ResolveWithNegativeCacheCallback(ctx, key, allowSelf, count)
+}
+
+// Subscribe implements ir.Router: it resolves the current providers of keys via the libp2p discovery path,
+// then delegates to r.subs to open a subscribe stream to each, so their future address changes are pushed
+// instead of requiring a re-Resolve.
+func (r *router) Subscribe(ctx context.Context, keys []string) (<-chan ir.PeerEvent, error) {
+	peerSet := map[peer.ID]struct{}{}
+	for _, key := range keys {
+		addrs, err := r.content.FindPeers(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+
+		for ai := range addrs {
+			if ai.ID == r.host.ID() {
+				continue
+			}
+			peerSet[ai.ID] = struct{}{}
+		}
+	}
+
+	peers := make([]peer.ID, 0, len(peerSet))
+	for p := range peerSet {
+		peers = append(peers, p)
+	}
+
+	return r.subs.Subscribe(ctx, keys, peers), nil
+}
+
+// Provide announces that this node can serve keys, to both content's libp2p discovery mechanism and, by
+// recording them in r.advertised, Handler's delegated routing HttpServer.
+func (r *router) Provide(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		if _, err := r.content.Advertise(ctx, key); err != nil {
+			return err
+		}
+		r.lookupCache.Del(key)
+	}
+
+	if r.advertised != nil {
+		r.advertised.add(keys)
+	}
+
+	return nil
+}
+
+// Advertise implements ir.Router.
+func (r *router) Advertise(ctx context.Context, keys []string) error {
+	return r.Provide(ctx, keys)
+}
+
+// Net implements ir.Router.
+func (r *router) Net() peernet.Network {
+	return r.net
+}
+
+// Close implements ir.Router.
+func (r *router) Close() error {
+	r.cancel()
+	return r.host.Close()
+}
+
+// newHost creates a libp2p host listening on addr, e.g. "0.0.0.0:5000".
+func newHost(addr string) (host.Host, error) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	listenAddr, err := multiaddr.NewMultiaddr(fmt.Sprintf("/ip4/0.0.0.0/tcp/%s", port))
+	if err != nil {
+		return nil, err
+	}
+
+	return libp2p.New(libp2p.ListenAddrs(listenAddr))
+}
+
+// NewRouter creates the production Router: a Kubernetes-aware, libp2p content-routing implementation,
+// augmented with a delegated HTTP lookup if httpEndpoints is non-empty. health, if non-nil, is consulted to
+// order Resolve's results and should be the same tracker fed by the caller's pkg/remote readers, so that
+// connection-quality feedback from actual reads improves subsequent lookups; health may be nil, in which
+// case Resolve skips health-aware ordering. persistentPeers, combined with whatever cs's node/pod
+// persistent-peers annotation adds, is kept connected for the router's lifetime via StartPersistentPeers,
+// which only runs if recorder is non-nil, since a persistent peer connecting or dropping is reported through
+// it; with a nil recorder, no persistent peers are started. The caller is responsible for mounting
+// Handler() at the delegated routing path on its own peer-registry HTTP server.
+func NewRouter(cs *k8s.ClientSet, h host.Host, peerRegistryPort string, cr corerouting.ContentRouting, lookupCache *ristretto.Cache, httpEndpoints []string, health *ir.HealthTracker, recorder events.Recorder, persistentPeers []string) (*router, error) {
+	n, err := peernet.New(h)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	r := &router{
+		k8sClient:        cs,
+		host:             h,
+		peerRegistryPort: peerRegistryPort,
+		lookupCache:      lookupCache,
+		content:          routing.NewRoutingDiscovery(cr),
+		net:              n,
+		advertised:       newAdvertisedKeys(),
+		health:           health,
+		cancel:           cancel,
+	}
+
+	if len(httpEndpoints) > 0 {
+		r.httpRouter = ir.NewHttpRouter(httpEndpoints)
+	}
+
+	if recorder != nil {
+		if err := ir.StartPersistentPeers(ctx, h, recorder, cs, persistentPeers); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+
+	subs, err := ir.NewSubscriptionManager(h, r.advertised.list, func(key string) { r.lookupCache.Del(key) })
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	subs.RegisterStreamHandler()
+	r.subs = subs
+
+	return r, nil
+}
+
+// Handler returns the http.Handler that answers delegated routing lookups, by CID, for the keys this node has
+// advertised, meant to be mounted at the IPIP-417 providers path on the node's peer registry HTTP server.
+func (r *router) Handler() *ir.HttpServer {
+	return ir.NewHttpServer(r.peerRegistryPort, func(c string) ([]ir.PeerInfo, bool) {
+		for _, key := range r.advertised.list() {
+			if id, err := ir.ContentID(key); err == nil && id.String() == c {
+				return []ir.PeerInfo{r.selfPeerInfo()}, true
+			}
+		}
+		return nil, false
+	})
+}
+
+// selfPeerInfo returns the PeerInfo this node advertises for itself in delegated routing responses,
+// extracting its IPv4 address the same way Resolve does for remote peers.
+func (r *router) selfPeerInfo() ir.PeerInfo {
+	pi := ir.PeerInfo{ID: r.host.ID()}
+	pi.Addr, pi.HttpHost, _ = ipv4HttpHost(r.host.Addrs(), r.peerRegistryPort)
+	return pi
+}
@@ -4,11 +4,15 @@ package routing
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
 
+	ir "github.com/azure/peerd/internal/routing"
 	"github.com/azure/peerd/pkg/k8s"
 	"github.com/dgraph-io/ristretto"
 	cid "github.com/ipfs/go-cid"
@@ -37,7 +41,7 @@ func TestResolveWithCache(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	h := &testHost{"host-id"}
+	h := &testHost{id: "host-id"}
 	key := "some-key"
 
 	tcr := &testCr{
@@ -76,9 +80,9 @@ func TestResolve(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	h := &testHost{"host-id"}
+	h := &testHost{id: "host-id"}
 	key := "some-key"
-	contentId, err := createContentId(key)
+	contentId, err := ir.ContentID(key)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -119,6 +123,100 @@ func TestResolve(t *testing.T) {
 	}
 }
 
+func TestResolveShortCircuitsOnNegativeCache(t *testing.T) {
+	c, err := ristretto.NewCache(&ristretto.Config{
+		NumCounters: 1e7,
+		MaxCost:     1000,
+		BufferItems: 64,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := &testHost{id: "host-id"}
+	key := "some-key"
+	contentId, err := ir.ContentID(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tcr := &testCr{
+		m: map[string][]string{
+			contentId.String(): {"10.0.0.1"},
+		},
+	}
+
+	r := &router{
+		k8sClient:        &fakeClientset,
+		host:             h,
+		peerRegistryPort: "5000",
+		lookupCache:      c,
+		content:          routing.NewRoutingDiscovery(tcr),
+	}
+
+	c.Set(key, strPeerNotFound, negativeCacheCost)
+	time.Sleep(250 * time.Millisecond) // allow cache to flush
+
+	ctx := context.Background()
+	got, err := r.Resolve(ctx, key, false, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for info := range got {
+		t.Errorf("expected no peers once %s is negatively cached, got %s", key, info)
+	}
+}
+
+func TestHandlerReturnsSelfAddr(t *testing.T) {
+	h := &testHost{id: "host-id", addrs: []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/10.0.0.5/tcp/5005")}}
+	key := "some-key"
+
+	r := &router{
+		host:             h,
+		peerRegistryPort: "5000",
+		advertised:       newAdvertisedKeys(),
+	}
+	r.advertised.add([]string{key})
+
+	contentId, err := ir.ContentID(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/routing/v1/providers/"+contentId.String(), nil)
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	var body struct {
+		Providers []struct {
+			ID       string   `json:"ID"`
+			Addrs    []string `json:"Addrs"`
+			HttpHost string   `json:"HttpHost"`
+		} `json:"Providers"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(body.Providers) != 1 {
+		t.Fatalf("expected 1 provider, got %d", len(body.Providers))
+	}
+
+	p := body.Providers[0]
+	if p.HttpHost != "https://10.0.0.5:5000" {
+		t.Errorf("expected http host https://10.0.0.5:5000, got %s", p.HttpHost)
+	}
+
+	if len(p.Addrs) != 1 || p.Addrs[0] != "/ip4/10.0.0.5/tcp/5000/https" {
+		t.Errorf("expected addr /ip4/10.0.0.5/tcp/5000/https, got %v", p.Addrs)
+	}
+}
+
 func TestProvide(t *testing.T) {
 	c, err := ristretto.NewCache(&ristretto.Config{
 		NumCounters: 1e7,
@@ -129,9 +227,9 @@ func TestProvide(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	h := &testHost{"host-id"}
+	h := &testHost{id: "host-id"}
 	key := "some-key"
-	contentId, err := createContentId(key)
+	contentId, err := ir.ContentID(key)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -238,12 +336,13 @@ func (t *testCr) Provide(ctx context.Context, c cid.Cid, advertise bool) error {
 var _ corerouting.ContentRouting = &testCr{}
 
 type testHost struct {
-	id peer.ID
+	id    peer.ID
+	addrs []multiaddr.Multiaddr
 }
 
 // Addrs implements host.Host.
-func (*testHost) Addrs() []multiaddr.Multiaddr {
-	panic("unimplemented")
+func (th *testHost) Addrs() []multiaddr.Multiaddr {
+	return th.addrs
 }
 
 // Close implements host.Host.
@@ -0,0 +1,32 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// CacheHits counts SyncMap Get calls that found the requested key.
+var CacheHits = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: "peerd",
+	Subsystem: "cache",
+	Name:      "hits_total",
+	Help:      "Number of SyncMap Get calls that found the requested key.",
+})
+
+// CacheMisses counts SyncMap Get calls that did not find the requested key.
+var CacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: "peerd",
+	Subsystem: "cache",
+	Name:      "misses_total",
+	Help:      "Number of SyncMap Get calls that did not find the requested key.",
+})
+
+// CacheEvictions counts entries evicted from a SyncMap to make room for a new key.
+var CacheEvictions = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: "peerd",
+	Subsystem: "cache",
+	Name:      "evictions_total",
+	Help:      "Number of entries evicted from a SyncMap to make room for a new key.",
+})